@@ -1,25 +1,280 @@
 package config
 
-import (
-	"fmt"
-	"os"
+import "time"
 
-	"github.com/joho/godotenv"
-)
+// Config 应用的全部配置
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Doubao      DoubaoConfig      `yaml:"doubao"`
+	OpenAI      OpenAIConfig      `yaml:"openai"`
+	History     HistoryConfig     `yaml:"history"`
+	Upstream    UpstreamConfig    `yaml:"upstream"`
+	Session     SessionConfig     `yaml:"session"`
+	Roles       RolesConfig       `yaml:"roles"`
+	Usage       UsageConfig       `yaml:"usage"`
+	Pricing     PricingConfig     `yaml:"pricing"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Tools       ToolsConfig       `yaml:"tools"`
+	Attachments AttachmentsConfig `yaml:"attachments"`
+	Knowledge   KnowledgeConfig   `yaml:"knowledge"`
+	Log         LogConfig         `yaml:"log"`
+}
+
+// ServerConfig HTTP服务配置
+type ServerConfig struct {
+	Addr string `yaml:"addr"` // 监听地址，如 :8080
+}
 
-var APIKey string
+// DoubaoConfig 豆包（火山方舟）提供方配置
+type DoubaoConfig struct {
+	APIKey        string `yaml:"api_key"`
+	APIURL        string `yaml:"api_url"`        // chat/completions 接口地址
+	Model         string `yaml:"model"`          // 模型ID或接入点ID
+	ContextWindow int    `yaml:"context_window"` // 0表示沿用 history.context_window
+}
 
-func LoadEnv() error {
-	// 尝试加载init/initApi.env文件
-	err := godotenv.Load("init/initApi.env")
-	if err != nil {
-		return fmt.Errorf("加载.env文件失败: %w", err)
+// OpenAIConfig OpenAI 兼容提供方配置，BaseURL 为空时不启用
+type OpenAIConfig struct {
+	BaseURL       string `yaml:"base_url"`
+	APIKey        string `yaml:"api_key"`
+	Model         string `yaml:"model"`
+	ContextWindow int    `yaml:"context_window"` // 0表示沿用 history.context_window
+}
+
+// HistoryConfig 会话历史处理配置（单位：token）
+type HistoryConfig struct {
+	Mode              string `yaml:"mode"`               // trim 或 summarize
+	ContextWindow     int    `yaml:"context_window"`     // 默认模型上下文窗口
+	CompletionReserve int    `yaml:"completion_reserve"` // 为模型回复预留的token数
+	SummaryMaxTokens  int    `yaml:"summary_max_tokens"` // 滚动摘要的最大长度
+}
+
+// UpstreamConfig 上游调用配置（时间单位：秒）
+type UpstreamConfig struct {
+	Timeout        int `yaml:"timeout"`         // 单次请求超时（流式请求为等待响应头的超时）
+	MaxRetries     int `yaml:"max_retries"`     // 可重试错误的最大重试次数
+	RequestTimeout int `yaml:"request_timeout"` // 单轮对话（含重试与流式输出）的总时限
+	// CancelPersist 客户端断开或超时时的持久化策略：
+	// none 不写入本轮任何消息；partial 写入用户消息及带中断标记的部分回复，尚未收到内容时同 none
+	CancelPersist string `yaml:"cancel_persist"`
+	// MaxConcurrent 每个提供方同时进行的上游请求数上限，0表示不限制；超出的请求按优先级排队
+	MaxConcurrent int `yaml:"max_concurrent"`
+	QueueWait     int `yaml:"queue_wait"` // 排队等待的最长时间（秒）
+	MaxQueue      int `yaml:"max_queue"`  // 每个提供方排队的请求数上限，0表示不限制
+}
+
+// SessionConfig 会话存储配置
+type SessionConfig struct {
+	Store string `yaml:"store"` // memory 或 bolt（file 为已弃用的旧名称）
+	Dir   string `yaml:"dir"`   // bolt 存储的目录，数据库文件为其中的 sessions.db
+	// ConcurrentTurns 同一会话上一轮对话未结束时新请求的处理方式：
+	// queue 排队等待上一轮结束；reject 直接拒绝
+	ConcurrentTurns string `yaml:"concurrent_turns"`
+	TurnWait        int    `yaml:"turn_wait"` // 排队等待的最长时间（秒），0表示不限时
+}
+
+// RolesConfig 角色目录配置
+type RolesConfig struct {
+	Dir            string `yaml:"dir"`             // 角色文件目录
+	ReloadInterval int    `yaml:"reload_interval"` // 热更新检查间隔（秒），0表示不热更新
+}
+
+// UsageConfig 用量统计配置
+type UsageConfig struct {
+	File string `yaml:"file"` // 用量账本文件，为空时仅保存在内存中
+}
+
+// PricingConfig 价格表，用于把token用量折算为费用
+type PricingConfig struct {
+	Currency string                `yaml:"currency"`
+	Models   map[string]ModelPrice `yaml:"models"` // 键为模型ID，"default" 为兜底价格
+}
+
+// ModelPrice 每千token的价格
+type ModelPrice struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
+}
+
+// RateLimitConfig 按客户端限流配置，作用于对话接口
+type RateLimitConfig struct {
+	Enabled           bool   `yaml:"enabled"`
+	KeyBy             string `yaml:"key_by"`              // api_key、session 或 ip，取不到时回退为ip
+	RequestsPerMinute int    `yaml:"requests_per_minute"` // 0表示不限制请求频率
+	Burst             int    `yaml:"burst"`               // 允许的突发请求数，0表示与 requests_per_minute 相同
+	TokensPerDay      int    `yaml:"tokens_per_day"`      // 每日token额度，0表示不限制
+}
+
+// AuthConfig 认证配置：静态API Key与签名令牌
+type AuthConfig struct {
+	Enabled     bool           `yaml:"enabled"`
+	Keys        []APIKeyConfig `yaml:"keys"`
+	TokenSecret string         `yaml:"token_secret"` // 签名令牌的HMAC密钥，为空时不支持令牌
+	TokenTTL    int            `yaml:"token_ttl"`    // 签发令牌的最长有效期（秒）
+}
+
+// APIKeyConfig 一个静态API Key及其对应的调用方
+type APIKeyConfig struct {
+	Key       string `yaml:"key"`
+	Principal string `yaml:"principal"`
+	Admin     bool   `yaml:"admin"`
+}
+
+// ToolsConfig 工具调用配置，角色在 tools 字段中声明可用的工具
+type ToolsConfig struct {
+	Enabled   bool `yaml:"enabled"`    // 上游模型不支持工具调用时可关闭
+	MaxRounds int  `yaml:"max_rounds"` // 单轮对话中最多执行工具的轮数
+}
+
+// AttachmentsConfig 图片附件配置
+type AttachmentsConfig struct {
+	Dir        string `yaml:"dir"`          // 附件目录，每个会话一个子目录
+	MaxImageMB int    `yaml:"max_image_mb"` // 单张图片的最大大小（MB）
+	MaxImages  int    `yaml:"max_images"`   // 单条消息最多附带的图片数
+}
+
+// KnowledgeConfig 文档检索配置：上传的文档切分后向量化，对话时检索相关片段注入提示词
+type KnowledgeConfig struct {
+	Enabled        bool    `yaml:"enabled"`
+	Dir            string  `yaml:"dir"`             // 向量索引目录
+	Provider       string  `yaml:"provider"`        // 提供向量化接口的提供方，空为默认
+	EmbeddingModel string  `yaml:"embedding_model"` // 向量化模型
+	ChunkSize      int     `yaml:"chunk_size"`      // 每个片段的最大字数
+	ChunkOverlap   int     `yaml:"chunk_overlap"`   // 相邻片段重叠的字数
+	TopK           int     `yaml:"top_k"`           // 每轮注入的片段数
+	MinScore       float64 `yaml:"min_score"`       // 余弦相似度低于此值的片段不注入
+	MaxDocumentMB  int     `yaml:"max_document_mb"` // 单个文档的最大大小（MB）
+}
+
+// LogConfig 日志配置
+type LogConfig struct {
+	Level           string `yaml:"level"`             // DEBUG/INFO/WARNING/ERROR
+	Dir             string `yaml:"dir"`               // 日志目录
+	Format          string `yaml:"format"`            // text 或 json
+	AsyncBufferSize int    `yaml:"async_buffer_size"` // 异步缓冲区大小，0表示同步写入
+	FlushInterval   int    `yaml:"flush_interval"`    // 异步刷新间隔（秒）
+	RotateInterval  string `yaml:"rotate_interval"`   // daily 或 hourly
+	MaxSizeMB       int    `yaml:"max_size_mb"`       // 单个日志文件上限，超出后切换到新文件，0表示不限
+	Compress        bool   `yaml:"compress"`          // 轮转出的文件在后台 gzip 压缩
+	MaxAgeDays      int    `yaml:"max_age_days"`      // 轮转文件保留天数，0表示不限
+	MaxTotalMB      int    `yaml:"max_total_mb"`      // 日志文件总大小上限，超出时删除最旧的轮转文件，0表示不限
+	// RouteStdLog 将标准库 log 与 log/slog 的输出写入本日志系统
+	RouteStdLog bool `yaml:"route_std_log"`
+	// RouteGin 以经本日志系统输出的结构化访问日志替代 gin 自带的请求日志
+	RouteGin bool `yaml:"route_gin"`
+}
+
+// C 当前生效的配置，由 Load 填充
+var C = Default()
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{Addr: ":8080"},
+		Doubao: DoubaoConfig{
+			APIURL: "https://ark.cn-beijing.volces.com/api/v3/chat/completions",
+			Model:  "ep-20250811150312-h4mvh",
+		},
+		History: HistoryConfig{
+			Mode:              "trim",
+			ContextWindow:     32768,
+			CompletionReserve: 4096,
+			SummaryMaxTokens:  1024,
+		},
+		Upstream: UpstreamConfig{
+			Timeout:        60,
+			MaxRetries:     3,
+			RequestTimeout: 180,
+			CancelPersist:  "none",
+			MaxConcurrent:  8,
+			QueueWait:      30,
+			MaxQueue:       100,
+		},
+		Session: SessionConfig{
+			Store:           "memory",
+			Dir:             "./data/sessions",
+			ConcurrentTurns: "queue",
+			TurnWait:        30,
+		},
+		Roles:   RolesConfig{Dir: "./roles", ReloadInterval: 5},
+		Pricing: PricingConfig{Currency: "CNY", Models: map[string]ModelPrice{}},
+		RateLimit: RateLimitConfig{
+			KeyBy:             "ip",
+			RequestsPerMinute: 60,
+		},
+		Auth:  AuthConfig{TokenTTL: 86400},
+		Tools: ToolsConfig{Enabled: true, MaxRounds: 5},
+		Attachments: AttachmentsConfig{
+			Dir:        "./data/attachments",
+			MaxImageMB: 5,
+			MaxImages:  4,
+		},
+		Knowledge: KnowledgeConfig{
+			Enabled:        true,
+			Dir:            "./data/knowledge",
+			EmbeddingModel: "doubao-embedding-text-240715",
+			ChunkSize:      500,
+			ChunkOverlap:   50,
+			TopK:           4,
+			MinScore:       0.3,
+			MaxDocumentMB:  2,
+		},
+		Log: LogConfig{
+			Level:           "INFO",
+			Dir:             "./logs",
+			Format:          "text",
+			AsyncBufferSize: 1000,
+			FlushInterval:   3,
+			RotateInterval:  "daily",
+			MaxSizeMB:       100,
+			Compress:        true,
+			MaxAgeDays:      30,
+			MaxTotalMB:      1024,
+		},
+	}
+}
+
+// DoubaoContextWindow 返回豆包提供方生效的上下文窗口
+func (c *Config) DoubaoContextWindow() int {
+	if c.Doubao.ContextWindow > 0 {
+		return c.Doubao.ContextWindow
+	}
+	return c.History.ContextWindow
+}
+
+// OpenAIContextWindow 返回 OpenAI 兼容提供方生效的上下文窗口
+func (c *Config) OpenAIContextWindow() int {
+	if c.OpenAI.ContextWindow > 0 {
+		return c.OpenAI.ContextWindow
 	}
+	return c.History.ContextWindow
+}
 
-	APIKey = os.Getenv("DOUBAO_API_KEY")
-	if APIKey == "" {
-		return fmt.Errorf("请在.env文件中设置 DOUBAO_API_KEY")
+// PriceFor 返回模型的价格，未配置时使用 default 价格，均未配置时为0
+func (c *Config) PriceFor(model string) ModelPrice {
+	if p, ok := c.Pricing.Models[model]; ok {
+		return p
 	}
+	return c.Pricing.Models["default"]
+}
+
+// UpstreamTimeout 返回单次上游请求超时
+func (c *Config) UpstreamTimeout() time.Duration {
+	return time.Duration(c.Upstream.Timeout) * time.Second
+}
+
+// TurnWait 返回同一会话排队等待上一轮对话的最长时间，0表示不限时
+func (c *Config) TurnWait() time.Duration {
+	return time.Duration(c.Session.TurnWait) * time.Second
+}
+
+// QueueWait 返回上游请求排队等待的最长时间
+func (c *Config) QueueWait() time.Duration {
+	return time.Duration(c.Upstream.QueueWait) * time.Second
+}
 
-	return nil
+// RequestTimeout 返回单轮对话的总时限
+func (c *Config) RequestTimeout() time.Duration {
+	return time.Duration(c.Upstream.RequestTimeout) * time.Second
 }
@@ -0,0 +1,475 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultConfigFile = "config.yaml"
+	envFile           = "init/initApi.env"
+)
+
+// ValidationError 配置校验错误，一次性列出所有问题
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "配置校验失败:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load 按 默认值 -> 配置文件 -> 环境变量 -> 命令行参数 的顺序逐层覆盖加载配置，
+// 校验通过后写入 C。配置文件路径由 -config 参数或 CONFIG_FILE 环境变量指定，
+// 未指定时尝试读取当前目录下的 config.yaml（不存在则跳过）
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("AiDemo", flag.ContinueOnError)
+	configFile := fs.String("config", "", "配置文件路径（YAML）")
+	flagValues := make(map[string]*string, len(flagBindings))
+	for _, b := range flagBindings {
+		flagValues[b.name] = fs.String(b.name, "", b.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// init/initApi.env 中的变量不会覆盖已存在的环境变量
+	if err := godotenv.Load(envFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("加载 %s 失败: %w", envFile, err)
+	}
+
+	cfg := Default()
+	var problems []string
+
+	path, explicit := *configFile, *configFile != ""
+	if !explicit {
+		path, explicit = os.Getenv("CONFIG_FILE"), os.Getenv("CONFIG_FILE") != ""
+	}
+	if !explicit {
+		path = defaultConfigFile
+	}
+	// 配置文件有误时继续加载其余各层，与校验问题一并报告
+	if err := loadFile(cfg, path, explicit); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	for _, b := range envBindings {
+		if v, ok := os.LookupEnv(b.key); ok && v != "" {
+			if err := b.apply(cfg, v); err != nil {
+				problems = append(problems, fmt.Sprintf("环境变量 %s: %v", b.key, err))
+			}
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, b := range flagBindings {
+			if b.name == f.Name {
+				if err := b.apply(cfg, *flagValues[b.name]); err != nil {
+					problems = append(problems, fmt.Sprintf("参数 -%s: %v", b.name, err))
+				}
+			}
+		}
+	})
+
+	problems = append(problems, cfg.Validate()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	C = cfg
+	return cfg, nil
+}
+
+// loadFile 读取YAML配置文件并覆盖到cfg上，required为false时文件不存在不视为错误
+func loadFile(cfg *Config, path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+	return nil
+}
+
+// Validate 校验配置，返回所有不合法字段的说明
+func (c *Config) Validate() []string {
+	var p []string
+	add := func(format string, args ...interface{}) {
+		p = append(p, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		add("server.addr 不能为空")
+	}
+
+	if c.Doubao.APIKey == "" {
+		add("doubao.api_key 不能为空（可通过 DOUBAO_API_KEY 设置）")
+	}
+	if !validURL(c.Doubao.APIURL) {
+		add("doubao.api_url 不是合法的 http(s) 地址: %q", c.Doubao.APIURL)
+	}
+	if c.Doubao.Model == "" {
+		add("doubao.model 不能为空")
+	}
+	if c.Doubao.ContextWindow < 0 {
+		add("doubao.context_window 不能为负数")
+	}
+
+	if c.OpenAI.BaseURL != "" {
+		if !validURL(c.OpenAI.BaseURL) {
+			add("openai.base_url 不是合法的 http(s) 地址: %q", c.OpenAI.BaseURL)
+		}
+		if c.OpenAI.Model == "" {
+			add("设置了 openai.base_url 时必须同时设置 openai.model")
+		}
+	}
+	if c.OpenAI.ContextWindow < 0 {
+		add("openai.context_window 不能为负数")
+	}
+
+	h := c.History
+	if h.Mode != "trim" && h.Mode != "summarize" {
+		add("history.mode 只能为 trim 或 summarize: %q", h.Mode)
+	}
+	if h.ContextWindow <= 0 {
+		add("history.context_window 必须为正整数")
+	}
+	if h.CompletionReserve <= 0 {
+		add("history.completion_reserve 必须为正整数")
+	}
+	if h.SummaryMaxTokens <= 0 {
+		add("history.summary_max_tokens 必须为正整数")
+	}
+	for name, window := range map[string]int{"doubao": c.DoubaoContextWindow(), "openai": c.OpenAIContextWindow()} {
+		if window > 0 && h.CompletionReserve >= window {
+			add("history.completion_reserve 必须小于 %s 的上下文窗口(%d)", name, window)
+		}
+	}
+	if h.Mode == "summarize" && h.CompletionReserve+h.SummaryMaxTokens >= h.ContextWindow {
+		add("history.completion_reserve 与 history.summary_max_tokens 之和必须小于 history.context_window")
+	}
+
+	u := c.Upstream
+	if u.Timeout <= 0 {
+		add("upstream.timeout 必须为正整数（秒）")
+	}
+	if u.MaxRetries < 0 {
+		add("upstream.max_retries 不能为负数")
+	}
+	if u.RequestTimeout <= 0 {
+		add("upstream.request_timeout 必须为正整数（秒）")
+	}
+	if u.CancelPersist != "none" && u.CancelPersist != "partial" {
+		add("upstream.cancel_persist 只能为 none 或 partial: %q", u.CancelPersist)
+	}
+	if u.MaxConcurrent < 0 {
+		add("upstream.max_concurrent 不能为负数")
+	}
+	if u.QueueWait <= 0 {
+		add("upstream.queue_wait 必须为正整数（秒）")
+	}
+	if u.MaxQueue < 0 {
+		add("upstream.max_queue 不能为负数")
+	}
+
+	switch c.Session.Store {
+	case "memory":
+	case "bolt", "file": // file 为 bolt 的旧名称，仍然接受
+		if c.Session.Dir == "" {
+			add("session.store 为 bolt 时 session.dir 不能为空")
+		}
+	default:
+		add("session.store 只能为 memory 或 bolt: %q", c.Session.Store)
+	}
+	if c.Session.ConcurrentTurns != "queue" && c.Session.ConcurrentTurns != "reject" {
+		add("session.concurrent_turns 只能为 queue 或 reject: %q", c.Session.ConcurrentTurns)
+	}
+	if c.Session.TurnWait < 0 {
+		add("session.turn_wait 不能为负数")
+	}
+
+	if c.Roles.Dir == "" {
+		add("roles.dir 不能为空")
+	}
+	if c.Roles.ReloadInterval < 0 {
+		add("roles.reload_interval 不能为负数")
+	}
+
+	for model, price := range c.Pricing.Models {
+		if price.Prompt < 0 || price.Completion < 0 {
+			add("pricing.models.%s 的价格不能为负数", model)
+		}
+	}
+
+	rl := c.RateLimit
+	switch rl.KeyBy {
+	case "api_key", "session", "ip":
+	default:
+		add("rate_limit.key_by 只能为 api_key、session 或 ip: %q", rl.KeyBy)
+	}
+	if rl.RequestsPerMinute < 0 || rl.Burst < 0 || rl.TokensPerDay < 0 {
+		add("rate_limit.requests_per_minute、burst、tokens_per_day 不能为负数")
+	}
+
+	a := c.Auth
+	if a.Enabled && len(a.Keys) == 0 && a.TokenSecret == "" {
+		add("启用 auth 时至少需要配置 auth.keys 或 auth.token_secret")
+	}
+	seenKeys := make(map[string]bool, len(a.Keys))
+	for i, k := range a.Keys {
+		if k.Key == "" || k.Principal == "" {
+			add("auth.keys[%d] 的 key 与 principal 不能为空", i)
+		}
+		if k.Key == placeholderAPIKey {
+			add("auth.keys[%d] 仍是示例配置中的占位 key %q，请替换为随机生成的 key", i, placeholderAPIKey)
+		}
+		if seenKeys[k.Key] {
+			add("auth.keys[%d] 的 key 重复", i)
+		}
+		seenKeys[k.Key] = true
+	}
+	if a.TokenSecret != "" && len(a.TokenSecret) < 16 {
+		add("auth.token_secret 至少需要16个字符")
+	}
+	if a.TokenTTL <= 0 {
+		add("auth.token_ttl 必须为正整数（秒）")
+	}
+
+	if c.Tools.MaxRounds <= 0 {
+		add("tools.max_rounds 必须为正整数")
+	}
+
+	if c.Attachments.Dir == "" {
+		add("attachments.dir 不能为空")
+	}
+	if c.Attachments.MaxImageMB <= 0 {
+		add("attachments.max_image_mb 必须为正整数")
+	}
+	if c.Attachments.MaxImages < 0 {
+		add("attachments.max_images 不能为负数")
+	}
+
+	k := c.Knowledge
+	if k.Dir == "" {
+		add("knowledge.dir 不能为空")
+	}
+	if k.Enabled && k.EmbeddingModel == "" {
+		add("启用 knowledge 时 knowledge.embedding_model 不能为空")
+	}
+	if k.ChunkSize <= 0 {
+		add("knowledge.chunk_size 必须为正整数")
+	}
+	if k.ChunkOverlap < 0 || k.ChunkOverlap >= k.ChunkSize {
+		add("knowledge.chunk_overlap 必须在 0 到 chunk_size 之间")
+	}
+	if k.TopK <= 0 {
+		add("knowledge.top_k 必须为正整数")
+	}
+	if k.MinScore < -1 || k.MinScore > 1 {
+		add("knowledge.min_score 必须在 -1 到 1 之间")
+	}
+	if k.MaxDocumentMB <= 0 {
+		add("knowledge.max_document_mb 必须为正整数")
+	}
+
+	switch strings.ToUpper(c.Log.Level) {
+	case "DEBUG", "INFO", "WARNING", "ERROR":
+	default:
+		add("log.level 只能为 DEBUG/INFO/WARNING/ERROR: %q", c.Log.Level)
+	}
+	if c.Log.Dir == "" {
+		add("log.dir 不能为空")
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		add("log.format 只能为 text 或 json: %q", c.Log.Format)
+	}
+	if c.Log.AsyncBufferSize < 0 {
+		add("log.async_buffer_size 不能为负数")
+	}
+	if c.Log.FlushInterval <= 0 {
+		add("log.flush_interval 必须为正整数（秒）")
+	}
+	if c.Log.RotateInterval != "daily" && c.Log.RotateInterval != "hourly" {
+		add("log.rotate_interval 只能为 daily 或 hourly: %q", c.Log.RotateInterval)
+	}
+	if c.Log.MaxSizeMB < 0 || c.Log.MaxAgeDays < 0 || c.Log.MaxTotalMB < 0 {
+		add("log.max_size_mb、log.max_age_days 与 log.max_total_mb 不能为负数")
+	}
+
+	return p
+}
+
+// placeholderAPIKey 示例配置中的占位 key，不允许直接使用
+const placeholderAPIKey = "sk-change-me"
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// envBinding 环境变量到配置字段的映射
+type envBinding struct {
+	key   string
+	apply func(c *Config, v string) error
+}
+
+// flagBinding 命令行参数到配置字段的映射
+type flagBinding struct {
+	name  string
+	usage string
+	apply func(c *Config, v string) error
+}
+
+func setString(field func(c *Config) *string) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func setBool(field func(c *Config) *bool) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("不是合法的布尔值: %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// setAPIKeys 解析 "principal:key[:admin]" 形式、逗号分隔的API Key列表
+func setAPIKeys(c *Config, v string) error {
+	var keys []APIKeyConfig
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "admin") {
+			return fmt.Errorf("应为 principal:key[:admin] 形式: %q", item)
+		}
+		keys = append(keys, APIKeyConfig{Principal: parts[0], Key: parts[1], Admin: len(parts) == 3})
+	}
+	c.Auth.Keys = keys
+	return nil
+}
+
+func setFloat(field func(c *Config) *float64) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return fmt.Errorf("不是合法的数值: %q", v)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func setInt(field func(c *Config) *int) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("不是合法的整数: %q", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+var envBindings = []envBinding{
+	{"SERVER_ADDR", setString(func(c *Config) *string { return &c.Server.Addr })},
+	{"DOUBAO_API_KEY", setString(func(c *Config) *string { return &c.Doubao.APIKey })},
+	{"DOUBAO_API_URL", setString(func(c *Config) *string { return &c.Doubao.APIURL })},
+	{"DOUBAO_MODEL", setString(func(c *Config) *string { return &c.Doubao.Model })},
+	{"DOUBAO_CONTEXT_WINDOW", setInt(func(c *Config) *int { return &c.Doubao.ContextWindow })},
+	{"OPENAI_BASE_URL", setString(func(c *Config) *string { return &c.OpenAI.BaseURL })},
+	{"OPENAI_API_KEY", setString(func(c *Config) *string { return &c.OpenAI.APIKey })},
+	{"OPENAI_MODEL", setString(func(c *Config) *string { return &c.OpenAI.Model })},
+	{"OPENAI_CONTEXT_WINDOW", setInt(func(c *Config) *int { return &c.OpenAI.ContextWindow })},
+	{"HISTORY_MODE", setString(func(c *Config) *string { return &c.History.Mode })},
+	{"CONTEXT_WINDOW", setInt(func(c *Config) *int { return &c.History.ContextWindow })},
+	{"COMPLETION_RESERVE", setInt(func(c *Config) *int { return &c.History.CompletionReserve })},
+	{"SUMMARY_MAX_TOKENS", setInt(func(c *Config) *int { return &c.History.SummaryMaxTokens })},
+	{"UPSTREAM_TIMEOUT", setInt(func(c *Config) *int { return &c.Upstream.Timeout })},
+	{"UPSTREAM_MAX_RETRIES", setInt(func(c *Config) *int { return &c.Upstream.MaxRetries })},
+	{"REQUEST_TIMEOUT", setInt(func(c *Config) *int { return &c.Upstream.RequestTimeout })},
+	{"CANCEL_PERSIST", setString(func(c *Config) *string { return &c.Upstream.CancelPersist })},
+	{"UPSTREAM_MAX_CONCURRENT", setInt(func(c *Config) *int { return &c.Upstream.MaxConcurrent })},
+	{"UPSTREAM_QUEUE_WAIT", setInt(func(c *Config) *int { return &c.Upstream.QueueWait })},
+	{"UPSTREAM_MAX_QUEUE", setInt(func(c *Config) *int { return &c.Upstream.MaxQueue })},
+	{"SESSION_STORE", setString(func(c *Config) *string { return &c.Session.Store })},
+	{"SESSION_DIR", setString(func(c *Config) *string { return &c.Session.Dir })},
+	{"SESSION_CONCURRENT_TURNS", setString(func(c *Config) *string { return &c.Session.ConcurrentTurns })},
+	{"SESSION_TURN_WAIT", setInt(func(c *Config) *int { return &c.Session.TurnWait })},
+	{"ROLES_DIR", setString(func(c *Config) *string { return &c.Roles.Dir })},
+	{"ROLES_RELOAD_INTERVAL", setInt(func(c *Config) *int { return &c.Roles.ReloadInterval })},
+	{"USAGE_FILE", setString(func(c *Config) *string { return &c.Usage.File })},
+	{"RATE_LIMIT_ENABLED", setBool(func(c *Config) *bool { return &c.RateLimit.Enabled })},
+	{"RATE_LIMIT_KEY_BY", setString(func(c *Config) *string { return &c.RateLimit.KeyBy })},
+	{"RATE_LIMIT_RPM", setInt(func(c *Config) *int { return &c.RateLimit.RequestsPerMinute })},
+	{"RATE_LIMIT_BURST", setInt(func(c *Config) *int { return &c.RateLimit.Burst })},
+	{"RATE_LIMIT_TOKENS_PER_DAY", setInt(func(c *Config) *int { return &c.RateLimit.TokensPerDay })},
+	{"AUTH_ENABLED", setBool(func(c *Config) *bool { return &c.Auth.Enabled })},
+	{"AUTH_KEYS", setAPIKeys},
+	{"AUTH_TOKEN_SECRET", setString(func(c *Config) *string { return &c.Auth.TokenSecret })},
+	{"AUTH_TOKEN_TTL", setInt(func(c *Config) *int { return &c.Auth.TokenTTL })},
+	{"TOOLS_ENABLED", setBool(func(c *Config) *bool { return &c.Tools.Enabled })},
+	{"TOOLS_MAX_ROUNDS", setInt(func(c *Config) *int { return &c.Tools.MaxRounds })},
+	{"ATTACHMENTS_DIR", setString(func(c *Config) *string { return &c.Attachments.Dir })},
+	{"ATTACHMENTS_MAX_IMAGE_MB", setInt(func(c *Config) *int { return &c.Attachments.MaxImageMB })},
+	{"ATTACHMENTS_MAX_IMAGES", setInt(func(c *Config) *int { return &c.Attachments.MaxImages })},
+	{"KNOWLEDGE_ENABLED", setBool(func(c *Config) *bool { return &c.Knowledge.Enabled })},
+	{"KNOWLEDGE_DIR", setString(func(c *Config) *string { return &c.Knowledge.Dir })},
+	{"KNOWLEDGE_PROVIDER", setString(func(c *Config) *string { return &c.Knowledge.Provider })},
+	{"KNOWLEDGE_EMBEDDING_MODEL", setString(func(c *Config) *string { return &c.Knowledge.EmbeddingModel })},
+	{"KNOWLEDGE_CHUNK_SIZE", setInt(func(c *Config) *int { return &c.Knowledge.ChunkSize })},
+	{"KNOWLEDGE_CHUNK_OVERLAP", setInt(func(c *Config) *int { return &c.Knowledge.ChunkOverlap })},
+	{"KNOWLEDGE_MAX_DOCUMENT_MB", setInt(func(c *Config) *int { return &c.Knowledge.MaxDocumentMB })},
+	{"KNOWLEDGE_TOP_K", setInt(func(c *Config) *int { return &c.Knowledge.TopK })},
+	{"KNOWLEDGE_MIN_SCORE", setFloat(func(c *Config) *float64 { return &c.Knowledge.MinScore })},
+	{"LOG_LEVEL", setString(func(c *Config) *string { return &c.Log.Level })},
+	{"LOG_DIR", setString(func(c *Config) *string { return &c.Log.Dir })},
+	{"LOG_FORMAT", setString(func(c *Config) *string { return &c.Log.Format })},
+	{"LOG_ASYNC_BUFFER_SIZE", setInt(func(c *Config) *int { return &c.Log.AsyncBufferSize })},
+	{"LOG_FLUSH_INTERVAL", setInt(func(c *Config) *int { return &c.Log.FlushInterval })},
+	{"LOG_ROTATE_INTERVAL", setString(func(c *Config) *string { return &c.Log.RotateInterval })},
+	{"LOG_MAX_SIZE_MB", setInt(func(c *Config) *int { return &c.Log.MaxSizeMB })},
+	{"LOG_COMPRESS", setBool(func(c *Config) *bool { return &c.Log.Compress })},
+	{"LOG_MAX_AGE_DAYS", setInt(func(c *Config) *int { return &c.Log.MaxAgeDays })},
+	{"LOG_MAX_TOTAL_MB", setInt(func(c *Config) *int { return &c.Log.MaxTotalMB })},
+	{"LOG_ROUTE_STD_LOG", setBool(func(c *Config) *bool { return &c.Log.RouteStdLog })},
+	{"LOG_ROUTE_GIN", setBool(func(c *Config) *bool { return &c.Log.RouteGin })},
+}
+
+var flagBindings = []flagBinding{
+	{"addr", "监听地址，如 :8080", setString(func(c *Config) *string { return &c.Server.Addr })},
+	{"doubao-api-url", "豆包 chat/completions 接口地址", setString(func(c *Config) *string { return &c.Doubao.APIURL })},
+	{"doubao-model", "豆包模型ID或接入点ID", setString(func(c *Config) *string { return &c.Doubao.Model })},
+	{"history-mode", "历史处理模式：trim 或 summarize", setString(func(c *Config) *string { return &c.History.Mode })},
+	{"context-window", "默认模型上下文窗口（token）", setInt(func(c *Config) *int { return &c.History.ContextWindow })},
+	{"session-store", "会话存储：memory，或 bolt（session.dir 下的 bbolt 数据库 sessions.db）", setString(func(c *Config) *string { return &c.Session.Store })},
+	{"session-dir", "file 会话存储目录", setString(func(c *Config) *string { return &c.Session.Dir })},
+	{"roles-dir", "角色文件目录", setString(func(c *Config) *string { return &c.Roles.Dir })},
+	{"log-level", "日志级别：DEBUG/INFO/WARNING/ERROR", setString(func(c *Config) *string { return &c.Log.Level })},
+	{"log-dir", "日志目录", setString(func(c *Config) *string { return &c.Log.Dir })},
+	{"log-format", "日志格式：text 或 json", setString(func(c *Config) *string { return &c.Log.Format })},
+	{"log-async-buffer-size", "异步日志缓冲区大小，0表示同步写入", setInt(func(c *Config) *int { return &c.Log.AsyncBufferSize })},
+}
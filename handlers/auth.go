@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"AiDemo/middleware"
+	"AiDemo/models"
+	"AiDemo/services"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WhoAmIHandler 返回当前调用方 GET /auth/me
+func WhoAmIHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"principal": middleware.CurrentPrincipal(c)})
+}
+
+// IssueTokenHandler 签发签名令牌 POST /auth/token
+// 普通调用方只能为自己签发；管理员可通过 principal、admin 为任意调用方签发
+func IssueTokenHandler(c *gin.Context) {
+	var req struct {
+		Principal string `json:"principal"`
+		Admin     bool   `json:"admin"`
+		TTL       int    `json:"ttl"` // 秒，0或超过上限时使用 auth.token_ttl
+	}
+	// 请求体可以为空，表示为自己签发默认有效期的令牌
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		middleware.RequestLog(c).Warning("请求参数解析失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误", "code": codeBadRequest})
+		return
+	}
+
+	caller := middleware.CurrentPrincipal(c)
+	subject := caller
+	if (req.Principal != "" && req.Principal != caller.ID) || (req.Admin && !caller.Admin) {
+		if !caller.Admin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "只能为自己签发令牌", "code": codeForbidden})
+			return
+		}
+		subject = models.Principal{ID: req.Principal, Admin: req.Admin}
+	}
+	if subject.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "principal 不能为空", "code": codeBadRequest})
+		return
+	}
+
+	token, expires, err := services.IssueToken(subject, time.Duration(req.TTL)*time.Second)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": codeBadRequest})
+		return
+	}
+	middleware.RequestLog(c).Info("已签发令牌(principal=%s, admin=%v, by=%s)", subject.ID, subject.Admin, caller.ID)
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expires, "principal": subject})
+}
@@ -1,76 +1,466 @@
 package handlers
 
 import (
+	"AiDemo/config"
+	"AiDemo/middleware"
 	"AiDemo/models"
 	"AiDemo/services"
-	"AiDemo/utils"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// 角色 -> 系统提示词
-var roleSystemPrompts = map[string]string{
-	"general":    "你是一个专业、友善且简洁的中文AI助理。要求：1) 理解用户真实意图，优先给出可执行答案；2) 回答清晰分点，必要时给示例；3) 不编造事实，未知则说明并给出获取方法；4) 默认使用简体中文；5) 保持礼貌且不啰嗦。",
-	"coder":      "你是资深全栈工程师与代码审阅者。要求：1) 以问题为导向，提供可运行代码与关键说明；2) 代码风格清晰、命名规范、错误处理完善；3) 指出潜在边界条件与复杂度；4) 能根据上下文给出重构建议；5) 输出中避免无意义的客套。默认中文回答。",
-	"translator": "你是专业中英互译员。要求：1) 优先保证语义准确，其次流畅自然；2) 根据语境选择直译或意译；3) 保留专有名词与技术术语；4) 提供1-2种可选表达以供选择；5) 如用户未说明目标语言，优先中译英。",
-	"pm":         "你是资深产品经理。要求：1) 澄清目标、用户、场景与约束；2) 以列表与结构化表达需求；3) 补充验收标准与关键KPI；4) 提供里程碑与风险缓解建议；5) 如问题含糊，先反问澄清。",
-	"scholar":    "你是学术写作与研究助手。要求：1) 用严谨学术语气组织内容；2) 先给提纲再展开；3) 引入必要定义、公式或参考路径；4) 强调方法、数据与限制；5) 避免臆测，必要时提示需查证。默认中文。",
+type chatRequest struct {
+	Message   string `json:"message"`
+	Role      string `json:"role"`
+	SessionID string `json:"session_id"`
+	Provider  string `json:"provider"` // 可选，覆盖角色默认的提供方
+	// 可选，图片附件：data URL 或纯 base64；multipart/form-data 请求中为 images 文件字段
+	Images []string `json:"images"`
+	// 可选，覆盖角色默认的生成参数（temperature、top_p、max_tokens 等）
+	models.GenerationParams
+
+	images []services.Image // 校验通过的图片
+}
+
+// errSaveAttachment 保存附件失败，属于服务端错误
+var errSaveAttachment = errors.New("保存图片失败")
+
+// 部分回复写入会话时追加的中断标记
+const partialReplyMarker = "\n\n[回复未完成：请求已取消]"
+
+// chatTurn 一轮对话的上下文。用户消息在本轮成功结束后才与回复一起写入会话，
+// 失败或取消时按 config.C.Upstream.CancelPersist 决定是否保留。
+// 从读取历史到写入回复期间持有会话的对话锁，同一会话的各轮依次进行
+type chatTurn struct {
+	c         *gin.Context
+	sessionID string
+	role      string
+	provider  services.Provider
+	opts      services.ChatOptions
+	userMsg   models.Message
+	query     string   // 用于检索文档的用户问题
+	knowledge []string // 角色关联的共享知识库
+	// citations 本轮检索到并注入提示词的文档片段
+	citations []models.Citation
+	// rewrite 非nil时本轮改写已有历史（重新生成或编辑消息），而不是追加
+	rewrite *historyRewrite
+	// unlock 释放会话的对话锁，本轮结束时经 release 调用
+	unlock func()
+	// compacting 为true时对话锁已交给后台的摘要压缩任务，由其释放
+	compacting bool
+}
+
+// historyRewrite 改写历史的一轮对话：保留base，其后写入本轮用户消息与回复
+type historyRewrite struct {
+	base  []models.Message     // 保留的历史
+	mark  services.HistoryMark // 读取历史时的快照，提交时据此检测并发修改
+	patch *models.SessionPatch // 切换角色时随改写一并应用，可为nil
 }
 
 func ChatHandler(c *gin.Context) {
-	var req struct {
-		Message   string `json:"message"`
-		Role      string `json:"role"`
-		SessionID string `json:"session_id"`
+	var req chatRequest
+	if err := bindChatRequest(c, &req); err != nil {
+		middleware.RequestLog(c).Warning("请求参数解析失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error(), "code": codeBadRequest})
+		return
+	}
+
+	turn, err := beginTurn(c, &req)
+	if err != nil {
+		respondTurnError(c, req.SessionID, err)
+		return
+	}
+	respondTurn(c, turn)
+}
+
+// ChatStreamHandler 以SSE方式向浏览器逐段推送AI回复，
+// 完整回复仅在流结束后写入会话历史
+func ChatStreamHandler(c *gin.Context) {
+	var req chatRequest
+	if err := bindChatRequest(c, &req); err != nil {
+		middleware.RequestLog(c).Warning("请求参数解析失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error(), "code": codeBadRequest})
+		return
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.Warning("请求参数解析失败: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+
+	turn, err := beginTurn(c, &req)
+	if err != nil {
+		respondTurnError(c, req.SessionID, err)
+		return
+	}
+	streamTurn(c, turn)
+}
+
+// respondTurn 执行一轮对话，以JSON返回完整回复
+func respondTurn(c *gin.Context, turn *chatTurn) {
+	defer turn.release()
+	sessionID := turn.sessionID
+
+	ctx, cancel := upstreamContext(c)
+	defer cancel()
+
+	turn.retrieve(ctx)
+
+	// 调用AI服务
+	middleware.RequestLog(c).Debug("开始调用AI服务(provider=%s)...", turn.provider.Name())
+	result, err := services.ChatWithTools(ctx, turn.provider, turn.prompt(), turn.opts, turn.toolContext(), nil, nil)
+	if err != nil {
+		if turn.cancelled(ctx, result) {
+			respondCancelled(c, ctx)
+			return
+		}
+		middleware.RequestLog(c).Error("AI服务调用失败: %v", err)
+		respondUpstreamError(c, err)
+		return
+	}
+
+	middleware.RequestLog(c).Debug("AI服务响应成功，长度: %d", len(result.Content))
+
+	// 记录本轮对话
+	usage, err := turn.commit(result)
+	if err != nil {
+		respondTurnError(c, sessionID, err)
 		return
 	}
+	turn.compact(ctx)
+
+	middleware.RequestLog(c).Info("返回AI回复给用户")
+	resp := gin.H{
+		"reply":      result.Content,
+		"session_id": sessionID,
+		"params":     turn.opts.Params,
+		"usage":      usage,
+	}
+	if len(result.ToolRuns) > 0 {
+		resp["tool_runs"] = result.ToolRuns
+	}
+	if len(turn.citations) > 0 {
+		resp["citations"] = turn.citations
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// streamTurn 执行一轮对话，以SSE逐段推送回复
+func streamTurn(c *gin.Context, turn *chatTurn) {
+	defer turn.release()
+	sessionID := turn.sessionID
+
+	ctx, cancel := upstreamContext(c)
+	defer cancel()
+
+	turn.retrieve(ctx)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	c.SSEvent("session", gin.H{"session_id": sessionID, "params": turn.opts.Params})
+	if len(turn.citations) > 0 {
+		c.SSEvent("citations", turn.citations)
+	}
+	c.Writer.Flush()
 
-	role := req.Role
-	if role == "" {
-		role = "general"
+	middleware.RequestLog(c).Debug("开始调用流式AI服务(provider=%s)...", turn.provider.Name())
+	onDelta := func(delta string) error {
+		c.SSEvent("delta", gin.H{"content": delta})
+		c.Writer.Flush()
+		return ctx.Err()
 	}
-	sysPrompt, ok := roleSystemPrompts[role]
-	if !ok {
-		sysPrompt = roleSystemPrompts["general"]
+	onTool := func(run services.ToolRun) {
+		c.SSEvent("tool", run)
+		c.Writer.Flush()
+	}
+	result, err := services.ChatWithTools(ctx, turn.provider, turn.prompt(), turn.opts, turn.toolContext(), onDelta, onTool)
+	if err != nil {
+		if turn.cancelled(ctx, result) {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				c.SSEvent("error", gin.H{"error": "请求超时", "code": codeRequestTimeout})
+				c.Writer.Flush()
+			}
+			return
+		}
+		middleware.RequestLog(c).Error("流式AI服务调用失败: %v", err)
+		_, body := upstreamErrorBody(err)
+		c.SSEvent("error", body)
+		c.Writer.Flush()
+		return
 	}
 
+	// 流完整结束后再记录本轮对话
+	usage, err := turn.commit(result)
+	if err != nil {
+		middleware.RequestLog(c).Warning("写入本轮对话失败: %v", err)
+		code := codeInternal
+		if errors.Is(err, services.ErrHistoryChanged) {
+			code = codeConflict
+		}
+		c.SSEvent("error", gin.H{"error": err.Error(), "code": code})
+		c.Writer.Flush()
+		return
+	}
+
+	middleware.RequestLog(c).Info("流式回复完成，长度: %d", len(result.Content))
+	c.SSEvent("done", gin.H{"session_id": sessionID, "usage": usage})
+	c.Writer.Flush()
+
+	turn.compact(ctx)
+}
+
+// respondTurnError 响应开始或提交一轮对话时的错误：会话访问错误按会话错误处理，
+// 历史被并发修改或会话忙返回409，其余视为参数错误
+func respondTurnError(c *gin.Context, sessionID string, err error) {
+	if errors.Is(err, services.ErrSessionForbidden) || errors.Is(err, services.ErrSessionNotFound) {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+	if errors.Is(err, services.ErrHistoryChanged) {
+		middleware.RequestLog(c).Warning("会话历史已被并发修改")
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": codeConflict})
+		return
+	}
+	if errors.Is(err, services.ErrSessionBusy) {
+		middleware.RequestLog(c).Warning("会话上一轮对话尚未结束(mode=%s)", config.C.Session.ConcurrentTurns)
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": codeSessionBusy})
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		middleware.RequestLog(c).Warning("客户端在排队期间断开")
+		return
+	}
+	if errors.Is(err, errSaveAttachment) {
+		middleware.RequestLog(c).Error("%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errSaveAttachment.Error(), "code": codeInternal})
+		return
+	}
+	middleware.RequestLog(c).Warning("请求参数无效: %v", err)
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": codeBadRequest})
+}
+
+// beginTurn 解析角色、提供方与会话，必要时初始化会话
+func beginTurn(c *gin.Context, req *chatRequest) (*chatTurn, error) {
 	sessionID := req.SessionID
 	if sessionID == "" {
 		sessionID = genSessionID()
 	}
 
-	utils.Info("收到用户消息: %s (role=%s, session=%s)", req.Message, role, sessionID)
+	role, err := turnRole(c, sessionID, req.Role)
+	if err != nil {
+		return nil, err
+	}
+	// 本轮此后的日志（含经请求ctx调用的服务）均带有会话与角色字段
+	log := middleware.AddLogFields(c, "session_id", sessionID, "role", role.ID)
+	opts, provider, err := turnOptions(c.Request.Context(), req, role)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("收到用户消息: %s (provider=%s, images=%d)", req.Message, provider.Name(), len(req.images))
 
-	// 初始化会话（若不存在）
-	if !services.HasSession(sessionID) {
-		services.ResetSession(sessionID, sysPrompt)
+	// 初始化会话（若不存在），已有会话须属于当前调用方
+	if err := services.OpenSession(sessionID, middleware.CurrentPrincipal(c), role.ID, role.SystemPrompt); err != nil {
+		return nil, err
 	}
-	// 追加用户消息
-	services.AppendMessage(sessionID, models.Message{Role: "user", Content: req.Message})
 
-	// 调用AI服务
-	utils.Debug("开始调用AI服务...")
-	respText, err := services.CallDoubao(services.GetHistory(sessionID))
+	unlock, err := services.LockTurn(c.Request.Context(), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	userMsg, err := userMessage(c.Request.Context(), sessionID, req)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	return &chatTurn{
+		c:         c,
+		sessionID: sessionID,
+		role:      role.ID,
+		provider:  provider,
+		opts:      opts,
+		userMsg:   userMsg,
+		query:     req.Message,
+		knowledge: role.Knowledge,
+		unlock:    unlock,
+	}, nil
+}
+
+// turnRole 确定本轮使用的角色：新会话使用请求中的角色，已有会话沿用会话的角色。
+// 已有会话的请求指定了不同角色时返回错误，切换角色须通过 PATCH /sessions/:id 或重新生成
+func turnRole(c *gin.Context, sessionID string, roleID string) (models.Role, error) {
+	meta, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c))
+	if errors.Is(err, services.ErrSessionNotFound) {
+		return services.ResolveRole(roleID), nil
+	}
 	if err != nil {
-		utils.Error("AI服务调用失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return models.Role{}, err
+	}
+	current := services.ResolveRole(meta.Role)
+	if roleID != "" && services.ResolveRole(roleID).ID != current.ID {
+		return models.Role{}, fmt.Errorf("会话当前角色为 %s，不能以角色 %s 继续对话，切换角色请使用 PATCH /sessions/:id", current.ID, roleID)
+	}
+	return current, nil
+}
+
+// turnOptions 合并并校验生成参数，选择本轮使用的提供方
+func turnOptions(ctx context.Context, req *chatRequest, role models.Role) (services.ChatOptions, services.Provider, error) {
+	params := role.Params.Merge(req.GenerationParams)
+	if problems := params.Validate(services.MaxTokensLimit()); len(problems) > 0 {
+		return services.ChatOptions{}, nil, fmt.Errorf("生成参数不合法: %s", strings.Join(problems, "; "))
+	}
+
+	providerName := req.Provider
+	if providerName == "" {
+		providerName = role.Provider
+	}
+	provider, err := services.GetProvider(providerName)
+	if err != nil {
+		return services.ChatOptions{}, nil, err
+	}
+	return services.ChatOptions{
+		Model:  role.Model,
+		Params: params,
+		Tools:  services.ToolDefinitions(ctx, role.Tools),
+	}, provider, nil
+}
+
+// userMessage 构造本轮用户消息，附带的图片保存为会话附件
+func userMessage(ctx context.Context, sessionID string, req *chatRequest) (models.Message, error) {
+	if len(req.images) == 0 {
+		return models.Message{Role: "user", Content: req.Message}, nil
+	}
+	parts := make([]models.ContentPart, 0, len(req.images)+1)
+	if req.Message != "" {
+		parts = append(parts, models.ContentPart{Type: models.PartText, Text: req.Message})
+	}
+	for _, img := range req.images {
+		part, err := services.SaveAttachment(ctx, sessionID, img)
+		if err != nil {
+			return models.Message{}, fmt.Errorf("%w: %v", errSaveAttachment, err)
+		}
+		parts = append(parts, part)
+	}
+	return models.NewMultipartMessage("user", parts), nil
+}
+
+// retrieve 从会话文档与角色关联的知识库中检索相关片段，
+// 检索失败不影响对话，仅本轮不注入资料
+func (t *chatTurn) retrieve(ctx context.Context) {
+	citations, usage, err := services.Retrieve(ctx, t.sessionID, t.knowledge, t.query)
+	middleware.ChargeTokens(t.c, usage.TotalTokens)
+	if err != nil {
+		middleware.RequestLog(t.c).Warning("检索文档失败，本轮不注入参考资料: %v", err)
+		return
+	}
+	t.citations = citations
+}
+
+// prompt 返回本轮发送给模型的消息（历史 + 检索到的参考资料 + 本轮用户消息）
+func (t *chatTurn) prompt() []models.Message {
+	pending := []models.Message{t.userMsg}
+	if len(t.citations) > 0 {
+		pending = append([]models.Message{services.KnowledgeMessage(t.citations)}, pending...)
+	}
+	if t.rewrite != nil {
+		return services.PromptFromHistory(t.c.Request.Context(), t.sessionID, t.provider, t.rewrite.base, pending...)
+	}
+	return services.PromptHistory(t.c.Request.Context(), t.sessionID, t.provider, pending...)
+}
+
+// toolContext 返回本轮工具调用可用的上下文
+func (t *chatTurn) toolContext() services.ToolContext {
+	if t.rewrite != nil {
+		return services.ToolContext{SessionID: t.sessionID, History: t.rewrite.base}
+	}
+	return services.ToolContext{SessionID: t.sessionID, History: services.GetHistory(t.c.Request.Context(), t.sessionID)}
+}
+
+// save 写入本轮用户消息与助手回复：普通对话追加到历史末尾，改写历史时原子地替换保留部分之后的消息
+func (t *chatTurn) save(reply models.Message) error {
+	if t.rewrite == nil {
+		ctx := t.c.Request.Context()
+		services.AppendMessage(ctx, t.sessionID, t.userMsg)
+		services.AppendMessage(ctx, t.sessionID, reply)
+		return nil
+	}
+	return services.RewriteHistory(t.sessionID, t.rewrite.mark, len(t.rewrite.base), t.rewrite.patch, t.userMsg, reply)
+}
+
+// commit 将本轮用户消息与助手回复写入会话并记录用量，返回带费用的用量。
+// 写入失败（如历史已被并发修改）时用量照常记录
+func (t *chatTurn) commit(result services.ChatResult) (models.Usage, error) {
+	err := t.save(models.Message{Role: "assistant", Content: result.Content})
+	middleware.ChargeTokens(t.c, result.Usage.TotalTokens)
+	return services.RecordUsage(t.c.Request.Context(), t.sessionID, t.role, result.Model, result.Usage), err
+}
+
+// compact 摘要模式下在后台压缩超出预算的旧轮次，不阻塞本次响应。
+// 压缩期间继续持有对话锁，同一会话的下一轮或改写待压缩完成后再读取历史
+func (t *chatTurn) compact(ctx context.Context) {
+	if !services.SummarizeEnabled() {
 		return
 	}
+	t.compacting = true
+	go func() {
+		defer t.unlock()
+		services.CompactSession(ctx, t.sessionID, t.provider)
+	}()
+}
 
-	utils.Debug("AI服务响应成功，长度: %d", len(respText))
+// release 释放会话的对话锁，锁已交给后台压缩任务时由其释放
+func (t *chatTurn) release() {
+	if !t.compacting {
+		t.unlock()
+	}
+}
 
-	// 记录助手回复
-	services.AppendMessage(sessionID, models.Message{Role: "assistant", Content: respText})
+// cancelled 判断本轮是否因客户端断开或超时而结束，是则按配置处理已收到的部分回复
+// 已消耗的token无论是否保留消息都计入用量与每日额度
+func (t *chatTurn) cancelled(ctx context.Context, partial services.ChatResult) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	middleware.RequestLog(t.c).Warning("本轮对话已取消: %v，已收到 %d 字节", ctx.Err(), len(partial.Content))
+	// 尚未收到任何内容时按 none 处理，避免只有标记的空回复作为上下文发给后续轮次
+	if config.C.Upstream.CancelPersist == "partial" && partial.Content != "" {
+		if err := t.save(models.Message{Role: "assistant", Content: partial.Content + partialReplyMarker}); err != nil {
+			middleware.RequestLog(t.c).Warning("保存部分回复失败: %v", err)
+		}
+	}
+	if partial.Usage.TotalTokens > 0 {
+		middleware.ChargeTokens(t.c, partial.Usage.TotalTokens)
+		services.RecordUsage(ctx, t.sessionID, t.role, partial.Model, partial.Usage)
+	}
+	return true
+}
 
-	utils.Info("返回AI回复给用户")
-	c.JSON(http.StatusOK, gin.H{"reply": respText, "session_id": sessionID})
+// respondCancelled 响应已取消的请求：超时返回504，客户端已断开则无需响应
+func respondCancelled(c *gin.Context, ctx context.Context) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "请求超时", "code": codeRequestTimeout})
+		return
+	}
+	c.Abort()
+}
+
+// upstreamContext 返回带单轮时限与上游调度优先级的ctx：经认证的管理员优先，
+// 未启用认证时的匿名调用方按普通优先级调度；请求头 X-Priority: batch 可将本次请求降为后台优先级
+func upstreamContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	priority := services.PriorityInteractive
+	switch {
+	case strings.EqualFold(c.GetHeader("X-Priority"), "batch"):
+		priority = services.PriorityBatch
+	case middleware.Authenticated(c) && middleware.CurrentPrincipal(c).Admin:
+		priority = services.PriorityAdmin
+	}
+	return context.WithTimeout(services.WithPriority(c.Request.Context(), priority), config.C.RequestTimeout())
 }
 
 func genSessionID() string {
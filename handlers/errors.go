@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"AiDemo/services"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 对外暴露的稳定错误码
+const (
+	codeUpstreamAuth        = "upstream_auth_failed"
+	codeRateLimited         = "rate_limited"
+	codeQuotaExceeded       = "quota_exceeded"
+	codeContextTooLong      = "context_too_long"
+	codeUpstreamUnavailable = "upstream_unavailable"
+	codeBadRequest          = "bad_request"
+	codeRequestTimeout      = "request_timeout"
+	codeForbidden           = "forbidden"
+	codeConflict            = "conflict"
+	codeSessionBusy         = "session_busy"
+	codeInternal            = "internal_error"
+)
+
+// upstreamErrorStatus 将上游错误类别映射为HTTP状态码与错误码
+func upstreamErrorStatus(err error) (int, string) {
+	ue, ok := services.AsUpstreamError(err)
+	if !ok {
+		return http.StatusInternalServerError, codeInternal
+	}
+	switch ue.Kind {
+	case services.ErrKindAuth:
+		// 上游鉴权失败是服务端配置问题，不应暴露为客户端的401
+		return http.StatusBadGateway, codeUpstreamAuth
+	case services.ErrKindRateLimited:
+		return http.StatusTooManyRequests, codeRateLimited
+	case services.ErrKindQuota:
+		return http.StatusPaymentRequired, codeQuotaExceeded
+	case services.ErrKindContextTooLong:
+		return http.StatusRequestEntityTooLarge, codeContextTooLong
+	case services.ErrKindUpstreamUnavailable:
+		return http.StatusServiceUnavailable, codeUpstreamUnavailable
+	case services.ErrKindBadRequest:
+		return http.StatusBadRequest, codeBadRequest
+	default:
+		return http.StatusBadGateway, codeInternal
+	}
+}
+
+// upstreamErrorBody 构造错误响应体，限流时附带建议的重试秒数
+func upstreamErrorBody(err error) (int, gin.H) {
+	status, code := upstreamErrorStatus(err)
+	body := gin.H{"error": err.Error(), "code": code}
+	if ue, ok := services.AsUpstreamError(err); ok && ue.Retryable() && ue.RetryAfter > 0 {
+		body["retry_after"] = int(math.Ceil(ue.RetryAfter.Seconds()))
+	}
+	return status, body
+}
+
+// respondUpstreamError 以JSON响应上游调用错误
+func respondUpstreamError(c *gin.Context, err error) {
+	status, body := upstreamErrorBody(err)
+	if secs, ok := body["retry_after"].(int); ok {
+		c.Header("Retry-After", strconv.Itoa(secs))
+	}
+	c.JSON(status, body)
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"AiDemo/config"
+	"AiDemo/middleware"
+	"AiDemo/services"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UploadSessionDocumentHandler 向会话上传文档，仅该会话检索 POST /sessions/:id/documents
+func UploadSessionDocumentHandler(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c)); err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+	// 上传期间持有对话锁，删除会话须等待上传完成，避免上传在删除后重新写出知识库文件
+	unlock, err := services.LockTurn(c.Request.Context(), sessionID)
+	if err != nil {
+		respondTurnError(c, sessionID, err)
+		return
+	}
+	defer unlock()
+	// 等待期间会话可能已被删除
+	if _, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c)); err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+	uploadDocument(c, services.SessionKnowledgeBase(sessionID))
+}
+
+// ListSessionDocumentsHandler 列出会话的文档 GET /sessions/:id/documents
+func ListSessionDocumentsHandler(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c)); err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+	listDocuments(c, services.SessionKnowledgeBase(sessionID))
+}
+
+// DeleteSessionDocumentHandler 删除会话的文档 DELETE /sessions/:id/documents/:doc_id
+func DeleteSessionDocumentHandler(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c)); err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+	deleteDocument(c, services.SessionKnowledgeBase(sessionID))
+}
+
+// ListKnowledgeBasesHandler 列出共享知识库 GET /knowledge
+func ListKnowledgeBasesHandler(c *gin.Context) {
+	bases, err := services.ListKnowledgeBases()
+	if err != nil {
+		respondKnowledgeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"knowledge_bases": bases})
+}
+
+// UploadKnowledgeDocumentHandler 向共享知识库上传文档，知识库不存在时自动创建，仅管理员可用
+// POST /knowledge/:name/documents
+func UploadKnowledgeDocumentHandler(c *gin.Context) {
+	base, ok := sharedKnowledgeBase(c, true)
+	if !ok {
+		return
+	}
+	uploadDocument(c, base)
+}
+
+// ListKnowledgeDocumentsHandler 列出共享知识库的文档 GET /knowledge/:name/documents
+func ListKnowledgeDocumentsHandler(c *gin.Context) {
+	base, ok := sharedKnowledgeBase(c, false)
+	if !ok {
+		return
+	}
+	listDocuments(c, base)
+}
+
+// DeleteKnowledgeDocumentHandler 删除共享知识库的文档，仅管理员可用
+// DELETE /knowledge/:name/documents/:doc_id
+func DeleteKnowledgeDocumentHandler(c *gin.Context) {
+	base, ok := sharedKnowledgeBase(c, true)
+	if !ok {
+		return
+	}
+	deleteDocument(c, base)
+}
+
+// sharedKnowledgeBase 校验路径中的知识库名称，adminOnly 时要求调用方为管理员
+func sharedKnowledgeBase(c *gin.Context, adminOnly bool) (string, bool) {
+	name := c.Param("name")
+	if !services.ValidKnowledgeBaseName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "知识库名称只能包含字母、数字、下划线与连字符", "code": codeBadRequest})
+		return "", false
+	}
+	if adminOnly && !middleware.CurrentPrincipal(c).Admin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员可修改共享知识库", "code": codeForbidden})
+		return "", false
+	}
+	return name, true
+}
+
+// uploadDocument 解析上传的文档并加入知识库。
+// JSON 请求体为 {"title", "content"}；multipart/form-data 中文档为 file 字段，标题为 title 字段
+func uploadDocument(c *gin.Context, base string) {
+	limit := int64(config.C.Knowledge.MaxDocumentMB<<20 + maxChatFieldsBytes)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	var title, source string
+	var data []byte
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 file 字段", "code": codeBadRequest})
+			return
+		}
+		f, err := fh.Open()
+		if err == nil {
+			data, err = io.ReadAll(f)
+			f.Close()
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "读取文件失败: " + err.Error(), "code": codeBadRequest})
+			return
+		}
+		title, source = c.PostForm("title"), fh.Filename
+		if title == "" {
+			title = strings.TrimSuffix(fh.Filename, filepath.Ext(fh.Filename))
+		}
+	} else {
+		var req struct {
+			Title   string `json:"title"`
+			Content string `json:"content"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error(), "code": codeBadRequest})
+			return
+		}
+		title, data = req.Title, []byte(req.Content)
+	}
+
+	text, err := services.ParseDocument(data)
+	if err != nil {
+		respondKnowledgeError(c, err)
+		return
+	}
+	doc, usage, err := services.AddDocument(c.Request.Context(), base, strings.TrimSpace(title), source, text)
+	middleware.ChargeTokens(c, usage.TotalTokens)
+	if err != nil {
+		respondKnowledgeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"document": doc})
+}
+
+func listDocuments(c *gin.Context, base string) {
+	docs, err := services.ListDocuments(base)
+	if err != nil {
+		respondKnowledgeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"documents": docs})
+}
+
+func deleteDocument(c *gin.Context, base string) {
+	if err := services.DeleteDocument(base, c.Param("doc_id")); err != nil {
+		respondKnowledgeError(c, err)
+		return
+	}
+	middleware.RequestLog(c).Info("文档已删除(base=%s, doc=%s)", base, c.Param("doc_id"))
+	c.Status(http.StatusNoContent)
+}
+
+// respondKnowledgeError 将文档操作错误映射为HTTP响应，向量化接口的错误按上游错误处理
+func respondKnowledgeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidDocument):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": codeBadRequest})
+	case errors.Is(err, services.ErrDocumentNotFound), errors.Is(err, services.ErrKnowledgeDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		if _, ok := services.AsUpstreamError(err); ok {
+			middleware.RequestLog(c).Error("文档向量化失败: %v", err)
+			respondUpstreamError(c, err)
+			return
+		}
+		middleware.RequestLog(c).Error("文档操作失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "code": codeInternal})
+	}
+}
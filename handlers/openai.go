@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"AiDemo/config"
+	"AiDemo/middleware"
+	"AiDemo/models"
+	"AiDemo/services"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAI 兼容接口：model 字段取角色ID，可用 "角色@提供方" 指定提供方，
+// 角色的系统提示词置于客户端消息之前，角色声明的工具在服务端执行。接口无状态，不写入会话历史
+
+// openAIChatRequest /v1/chat/completions 请求体
+type openAIChatRequest struct {
+	Model         string                `json:"model"`
+	Messages      []models.Message      `json:"messages"` // content 可以是字符串或内容片段数组
+	Stream        bool                  `json:"stream"`
+	StreamOptions *models.StreamOptions `json:"stream_options"`
+	N             *int                  `json:"n"`
+
+	Temperature         *float64  `json:"temperature"`
+	TopP                *float64  `json:"top_p"`
+	MaxTokens           *int      `json:"max_tokens"`
+	MaxCompletionTokens *int      `json:"max_completion_tokens"`
+	Stop                stopField `json:"stop"`
+	FrequencyPenalty    *float64  `json:"frequency_penalty"`
+	PresencePenalty     *float64  `json:"presence_penalty"`
+	Seed                *int64    `json:"seed"`
+}
+
+// stopField stop 可以是单个字符串或字符串数组
+type stopField []string
+
+func (s *stopField) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = stopField{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("stop 必须是字符串或字符串数组")
+	}
+	*s = many
+	return nil
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// openAIDelta 流式片段中的增量，未设置的字段不输出
+type openAIDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int             `json:"index"`
+	Message      *models.Message `json:"message,omitempty"`
+	Delta        *openAIDelta    `json:"delta,omitempty"`
+	FinishReason *string         `json:"finish_reason"`
+}
+
+type openAICompletion struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+// OpenAIModelsHandler 列出可用模型（即角色） GET /v1/models
+func OpenAIModelsHandler(c *gin.Context) {
+	roles := services.ListRoles()
+	data := make([]gin.H, 0, len(roles))
+	for _, role := range roles {
+		owner := role.Provider
+		if owner == "" {
+			owner = services.DefaultProviderName
+		}
+		data = append(data, gin.H{"id": role.ID, "object": "model", "created": 0, "owned_by": owner})
+	}
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+}
+
+// OpenAIChatCompletionsHandler OpenAI 兼容的对话接口 POST /v1/chat/completions
+func OpenAIChatCompletionsHandler(c *gin.Context) {
+	var req openAIChatRequest
+	limitChatBody(c)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.RequestLog(c).Warning("请求参数解析失败: %v", err)
+		respondOpenAIError(c, http.StatusBadRequest, codeBadRequest, "参数错误: "+err.Error())
+		return
+	}
+
+	role, provider, err := resolveOpenAIModel(req.Model)
+	if err != nil {
+		respondOpenAIError(c, http.StatusNotFound, "model_not_found", err.Error())
+		return
+	}
+	if req.N != nil && *req.N != 1 {
+		respondOpenAIError(c, http.StatusBadRequest, codeBadRequest, "仅支持 n=1")
+		return
+	}
+
+	override := models.GenerationParams{
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		MaxTokens:        req.MaxTokens,
+		Stop:             req.Stop,
+		FrequencyPenalty: req.FrequencyPenalty,
+		PresencePenalty:  req.PresencePenalty,
+		Seed:             req.Seed,
+	}
+	if override.MaxTokens == nil {
+		override.MaxTokens = req.MaxCompletionTokens
+	}
+	params := role.Params.Merge(override)
+	if problems := params.Validate(services.MaxTokensLimit()); len(problems) > 0 {
+		respondOpenAIError(c, http.StatusBadRequest, codeBadRequest, "生成参数不合法: "+strings.Join(problems, "; "))
+		return
+	}
+
+	messages, err := openAIPrompt(role, req.Messages)
+	if err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := upstreamContext(c)
+	defer cancel()
+
+	opts := services.ChatOptions{Model: role.Model, Params: params, Tools: services.ToolDefinitions(ctx, role.Tools)}
+	// 接口无状态，历史检索类工具在客户端提供的消息中查找
+	tc := services.ToolContext{History: messages}
+	principal := middleware.CurrentPrincipal(c)
+	middleware.RequestLog(c).Info("收到OpenAI兼容请求(model=%s, role=%s, provider=%s, principal=%s, stream=%v, messages=%d)",
+		req.Model, role.ID, provider.Name(), principal.ID, req.Stream, len(messages))
+
+	completion := openAICompletion{
+		ID:      "chatcmpl-" + genSessionID(),
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+	}
+
+	if !req.Stream {
+		result, err := services.ChatWithTools(ctx, provider, messages, opts, tc, nil, nil)
+		recordOpenAIUsage(c, role.ID, result)
+		if err != nil {
+			respondOpenAIUpstreamError(c, ctx, err)
+			return
+		}
+		completion.Object = "chat.completion"
+		completion.Choices = []openAIChoice{{
+			Message:      &models.Message{Role: "assistant", Content: result.Content},
+			FinishReason: finishReason(result),
+		}}
+		completion.Usage = toOpenAIUsage(result.Usage)
+		c.JSON(http.StatusOK, completion)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	completion.Object = "chat.completion.chunk"
+	writeChunk := func(choice openAIChoice, usage *openAIUsage) {
+		chunk := completion
+		chunk.Choices = []openAIChoice{choice}
+		if usage != nil {
+			chunk.Choices = []openAIChoice{}
+			chunk.Usage = usage
+		}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+		c.Writer.Flush()
+	}
+
+	writeChunk(openAIChoice{Delta: &openAIDelta{Role: "assistant"}}, nil)
+	result, err := services.ChatWithTools(ctx, provider, messages, opts, tc, func(delta string) error {
+		writeChunk(openAIChoice{Delta: &openAIDelta{Content: delta}}, nil)
+		return ctx.Err()
+	}, nil)
+	recordOpenAIUsage(c, role.ID, result)
+	if err != nil {
+		if ctx.Err() == nil {
+			middleware.RequestLog(c).Error("流式AI服务调用失败: %v", err)
+			_, code := upstreamErrorStatus(err)
+			data, _ := json.Marshal(gin.H{"error": gin.H{"message": err.Error(), "type": code, "code": code}})
+			fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+		}
+		c.Writer.Flush()
+		return
+	}
+
+	writeChunk(openAIChoice{Delta: &openAIDelta{}, FinishReason: finishReason(result)}, nil)
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		writeChunk(openAIChoice{}, toOpenAIUsage(result.Usage))
+	}
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+	middleware.RequestLog(c).Info("OpenAI兼容流式回复完成，长度: %d", len(result.Content))
+}
+
+// resolveOpenAIModel 将 model 字段映射为角色与提供方，格式为 "角色" 或 "角色@提供方"
+func resolveOpenAIModel(model string) (models.Role, services.Provider, error) {
+	roleID, providerName, _ := strings.Cut(model, "@")
+	role, ok := services.GetRole(roleID)
+	if !ok {
+		return models.Role{}, nil, fmt.Errorf("模型不存在: %q，可通过 GET /v1/models 查看可用模型", model)
+	}
+	if providerName == "" {
+		providerName = role.Provider
+	}
+	provider, err := services.GetProvider(providerName)
+	if err != nil {
+		return models.Role{}, nil, err
+	}
+	return role, provider, nil
+}
+
+// openAIPrompt 在客户端消息前加上角色的系统提示词
+func openAIPrompt(role models.Role, in []models.Message) ([]models.Message, error) {
+	if len(in) == 0 {
+		return nil, errors.New("messages 不能为空")
+	}
+	messages := make([]models.Message, 0, len(in)+1)
+	if role.SystemPrompt != "" {
+		messages = append(messages, models.Message{Role: "system", Content: role.SystemPrompt})
+	}
+	for i, m := range in {
+		switch m.Role {
+		case "system", "developer", "user", "assistant":
+		default:
+			return nil, fmt.Errorf("messages[%d] 的 role 不受支持: %q", i, m.Role)
+		}
+		if err := validateOpenAIParts(m.Parts); err != nil {
+			return nil, fmt.Errorf("messages[%d]: %v", i, err)
+		}
+		if m.Role == "developer" {
+			m.Role = "system"
+		}
+		messages = append(messages, models.Message{Role: m.Role, Content: m.Content, Parts: m.Parts})
+	}
+	return messages, nil
+}
+
+// validateOpenAIParts 校验内容片段：仅支持文本与图片，图片须为 http(s) 地址或合法的 data URL
+func validateOpenAIParts(parts []models.ContentPart) error {
+	images := 0
+	for _, p := range parts {
+		switch p.Type {
+		case models.PartText:
+		case models.PartImage:
+			images++
+			if p.ImageURL == nil {
+				return errors.New("image_url 片段缺少 url")
+			}
+			url := p.ImageURL.URL
+			switch {
+			case strings.HasPrefix(url, "data:"):
+				if _, err := services.DecodeImage(url); err != nil {
+					return err
+				}
+			case strings.HasPrefix(url, "http://"), strings.HasPrefix(url, "https://"):
+			default:
+				return errors.New("图片地址只能是 http(s) 地址或 data URL")
+			}
+		default:
+			return fmt.Errorf("不支持的内容类型: %q", p.Type)
+		}
+	}
+	if images > config.C.Attachments.MaxImages {
+		return fmt.Errorf("最多附带 %d 张图片", config.C.Attachments.MaxImages)
+	}
+	return nil
+}
+
+// recordOpenAIUsage 记录用量并计入限流额度，OpenAI 兼容接口不关联会话
+func recordOpenAIUsage(c *gin.Context, role string, result services.ChatResult) {
+	if result.Usage.TotalTokens == 0 {
+		return
+	}
+	middleware.ChargeTokens(c, result.Usage.TotalTokens)
+	services.RecordUsage(c.Request.Context(), "", role, result.Model, result.Usage)
+}
+
+func finishReason(result services.ChatResult) *string {
+	reason := result.FinishReason
+	if reason == "" {
+		reason = "stop"
+	}
+	return &reason
+}
+
+func toOpenAIUsage(u models.Usage) *openAIUsage {
+	return &openAIUsage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, TotalTokens: u.TotalTokens}
+}
+
+// respondOpenAIError 以 OpenAI 的错误格式响应
+func respondOpenAIError(c *gin.Context, status int, code string, message string) {
+	c.JSON(status, gin.H{"error": gin.H{"message": message, "type": code, "code": code}})
+}
+
+// respondOpenAIUpstreamError 将上游错误或取消映射为 OpenAI 格式的错误响应
+func respondOpenAIUpstreamError(c *gin.Context, ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			respondOpenAIError(c, http.StatusGatewayTimeout, codeRequestTimeout, "请求超时")
+			return
+		}
+		c.Abort()
+		return
+	}
+	middleware.RequestLog(c).Error("AI服务调用失败: %v", err)
+	status, body := upstreamErrorBody(err)
+	if secs, ok := body["retry_after"].(int); ok {
+		c.Header("Retry-After", strconv.Itoa(secs))
+	}
+	respondOpenAIError(c, status, body["code"].(string), err.Error())
+}
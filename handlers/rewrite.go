@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"AiDemo/middleware"
+	"AiDemo/models"
+	"AiDemo/services"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegenerateHandler 重新生成会话的最后一条助手回复，可通过 role、provider 与生成参数覆盖本次调用
+// POST /sessions/:id/regenerate[?stream=true]
+func RegenerateHandler(c *gin.Context) {
+	var req chatRequest
+	// 请求体可省略，此时沿用会话当前的角色与默认参数
+	if err := bindChatRequest(c, &req); err != nil && !errors.Is(err, io.EOF) {
+		middleware.RequestLog(c).Warning("请求参数解析失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error(), "code": codeBadRequest})
+		return
+	}
+	if req.Message != "" || len(req.images) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 重新生成不接受 message 与 images，修改提问请使用 PUT /sessions/:id/messages/:index", "code": codeBadRequest})
+		return
+	}
+
+	runRewrite(c, &req, -1)
+}
+
+// EditMessageHandler 修改一条用户消息，丢弃其后的全部历史并重新生成回复。
+// index 为消息在 GET /sessions/:id/messages 返回的 messages 中的下标，last 表示最后一条用户消息
+// PUT /sessions/:id/messages/:index[?stream=true]
+func EditMessageHandler(c *gin.Context) {
+	var req chatRequest
+	if err := bindChatRequest(c, &req); err != nil {
+		middleware.RequestLog(c).Warning("请求参数解析失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error(), "code": codeBadRequest})
+		return
+	}
+	if req.Message == "" && len(req.images) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: message 与 images 不能同时为空", "code": codeBadRequest})
+		return
+	}
+
+	index := 0
+	if p := c.Param("index"); p != "last" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: index 须为正整数或 last", "code": codeBadRequest})
+			return
+		}
+		index = n
+	}
+
+	runRewrite(c, &req, index)
+}
+
+// runRewrite 开始改写历史的一轮对话并按 stream 查询参数选择响应方式
+func runRewrite(c *gin.Context, req *chatRequest, index int) {
+	req.SessionID = c.Param("id")
+	turn, err := beginRewrite(c, req, index)
+	if err != nil {
+		respondTurnError(c, req.SessionID, err)
+		return
+	}
+
+	if stream, _ := strconv.ParseBool(c.Query("stream")); stream {
+		streamTurn(c, turn)
+		return
+	}
+	respondTurn(c, turn)
+}
+
+// beginRewrite 读取会话历史并确定保留范围与本轮用户消息。
+// index 为 -1 时重新生成最后一条回复；为 0 时编辑最后一条用户消息；否则编辑该下标的用户消息。
+// 历史在提交时按读取时的快照校验，期间被其他请求修改则整轮作废
+func beginRewrite(c *gin.Context, req *chatRequest, index int) (turn *chatTurn, err error) {
+	sessionID := req.SessionID
+	meta, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c))
+	if err != nil {
+		return nil, err
+	}
+
+	roleID := meta.Role
+	if req.Role != "" {
+		roleID = req.Role
+	}
+	role, ok := services.GetRole(roleID)
+	if !ok {
+		if req.Role != "" {
+			return nil, fmt.Errorf("未知角色: %s", req.Role)
+		}
+		role = services.ResolveRole(roleID)
+	}
+
+	log := middleware.AddLogFields(c, "session_id", sessionID, "role", role.ID)
+	opts, provider, err := turnOptions(c.Request.Context(), req, role)
+	if err != nil {
+		return nil, err
+	}
+
+	// 持有对话锁后再读取历史，出错时释放
+	unlock, err := services.LockTurn(c.Request.Context(), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			unlock()
+		}
+	}()
+
+	h := services.GetHistory(c.Request.Context(), sessionID)
+	rw := &historyRewrite{mark: services.MarkHistory(h)}
+
+	var userMsg models.Message
+	if index < 0 {
+		n := len(h)
+		if n < 3 || h[n-1].Role != "assistant" || h[n-2].Role != "user" {
+			return nil, errors.New("会话最后一条消息不是助手回复，无法重新生成")
+		}
+		rw.base = h[:n-2]
+		userMsg = h[n-2]
+		log.Info("重新生成回复(provider=%s)", provider.Name())
+	} else {
+		if index == 0 {
+			for i := len(h) - 1; i > 0; i-- {
+				if h[i].Role == "user" {
+					index = i
+					break
+				}
+			}
+			if index == 0 {
+				return nil, errors.New("会话中没有可编辑的用户消息")
+			}
+		}
+		if index >= len(h) || h[index].Role != "user" {
+			return nil, fmt.Errorf("第 %d 条消息不存在或不是用户消息", index)
+		}
+		rw.base = h[:index]
+		if userMsg, err = userMessage(c.Request.Context(), sessionID, req); err != nil {
+			return nil, err
+		}
+		log.Info("编辑第 %d 条消息并重新生成(provider=%s, 丢弃 %d 条)", index, provider.Name(), len(h)-index)
+	}
+
+	// 请求指定了不同的角色时替换system提示词，与改写一并生效。
+	// 会话的角色已被删除而回退到默认角色时不算切换，保留会话原有的角色与提示词
+	if req.Role != "" && role.ID != meta.Role {
+		rest := rw.base
+		if len(rest) > 0 && rest[0].Role == "system" {
+			rest = rest[1:]
+		}
+		rw.base = append([]models.Message{{Role: "system", Content: role.SystemPrompt}}, rest...)
+		rw.patch = &models.SessionPatch{Role: &role.ID, SystemPrompt: &role.SystemPrompt}
+	}
+
+	return &chatTurn{
+		c:         c,
+		sessionID: sessionID,
+		role:      role.ID,
+		provider:  provider,
+		opts:      opts,
+		userMsg:   userMsg,
+		query:     userMsg.Content,
+		knowledge: role.Knowledge,
+		rewrite:   rw,
+		unlock:    unlock,
+	}, nil
+}
@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"AiDemo/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRolesHandler 列出所有角色 GET /roles
+func ListRolesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"roles":   services.ListRoles(),
+		"default": services.DefaultRoleID,
+	})
+}
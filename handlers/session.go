@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"AiDemo/middleware"
+	"AiDemo/models"
+	"AiDemo/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 导入请求体的最大字节数，导出记录中内嵌了图片
+const maxImportBytes = 64 << 20
+
+// ListSessionsHandler 列出当前调用方的会话（管理员为全部会话） GET /sessions
+func ListSessionsHandler(c *gin.Context) {
+	metas, err := services.ListSessions(middleware.CurrentPrincipal(c))
+	if err != nil {
+		middleware.RequestLog(c).Error("列出会话失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": metas})
+}
+
+// GetSessionMessagesHandler 查看会话历史 GET /sessions/:id/messages
+func GetSessionMessagesHandler(c *gin.Context) {
+	sessionID := c.Param("id")
+	meta, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c))
+	if err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"session":  meta,
+		"messages": services.GetHistory(c.Request.Context(), sessionID),
+		"summary":  services.GetSummary(c.Request.Context(), sessionID),
+	})
+}
+
+// GetAttachmentHandler 读取会话中上传的图片 GET /sessions/:id/attachments/:name
+func GetAttachmentHandler(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c)); err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+	path, err := services.AttachmentPath(sessionID, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.File(path)
+}
+
+// ExportSessionHandler 导出会话记录 GET /sessions/:id/export?format=md|json|html
+// 默认 md；json 可通过 POST /sessions/import 重新导入，json 与 html 内嵌图片
+func ExportSessionHandler(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c)); err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+
+	format := c.DefaultQuery("format", "md")
+	if format != "md" && format != "json" && format != "html" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format 只能为 md、json 或 html", "code": codeBadRequest})
+		return
+	}
+	exp, err := services.ExportSession(c.Request.Context(), sessionID, format != "md")
+	if err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFileName(sessionID, format)))
+	switch format {
+	case "json":
+		c.IndentedJSON(http.StatusOK, exp)
+	case "html":
+		page, err := services.RenderHTML(exp)
+		if err != nil {
+			respondSessionError(c, sessionID, err)
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+	default:
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(services.RenderMarkdown(exp)))
+	}
+	middleware.RequestLog(c).Info("会话已导出(session=%s, format=%s)", sessionID, format)
+}
+
+// ImportSessionHandler 从 JSON 导出记录重新创建会话 POST /sessions/import
+// 请求体为导出的JSON，或 multipart/form-data 的 file 字段；可用查询参数 session_id 指定新会话ID，默认自动生成
+func ImportSessionHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	var exp services.SessionExport
+	var err error
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		var fh *multipart.FileHeader
+		if fh, err = c.FormFile("file"); err == nil {
+			var f multipart.File
+			if f, err = fh.Open(); err == nil {
+				err = json.NewDecoder(f).Decode(&exp)
+				f.Close()
+			}
+		}
+	} else {
+		err = c.ShouldBindJSON(&exp)
+	}
+	if err != nil {
+		middleware.RequestLog(c).Warning("导入数据解析失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error(), "code": codeBadRequest})
+		return
+	}
+
+	sessionID := c.Query("session_id")
+	if sessionID == "" {
+		sessionID = genSessionID()
+	}
+	// 持有对话锁，避免与同ID会话上进行中的对话交错写入
+	unlock, err := services.LockTurn(c.Request.Context(), sessionID)
+	if err != nil {
+		respondTurnError(c, sessionID, err)
+		return
+	}
+	defer unlock()
+	meta, err := services.ImportSession(c.Request.Context(), exp, middleware.CurrentPrincipal(c), sessionID)
+	switch {
+	case err == nil:
+		middleware.RequestLog(c).Info("会话已导入(session=%s, messages=%d)", sessionID, meta.MessageCount)
+		c.JSON(http.StatusCreated, gin.H{"session": meta})
+	case errors.Is(err, services.ErrInvalidImport):
+		middleware.RequestLog(c).Warning("导入数据无效: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": codeBadRequest})
+	case errors.Is(err, services.ErrSessionExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": codeConflict})
+	default:
+		respondSessionError(c, sessionID, err)
+	}
+}
+
+// exportFileName 生成下载文件名，会话ID中的特殊字符替换为下划线
+func exportFileName(sessionID, format string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, sessionID)
+	return "session-" + safe + "." + format
+}
+
+// DeleteSessionHandler 删除会话 DELETE /sessions/:id
+func DeleteSessionHandler(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c)); err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+	// 等待进行中的对话结束后再删除，避免其回复在删除后重新创建会话
+	unlock, err := services.LockTurn(c.Request.Context(), sessionID)
+	if err != nil {
+		respondTurnError(c, sessionID, err)
+		return
+	}
+	defer unlock()
+	if err := services.DeleteSession(c.Request.Context(), sessionID); err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+	middleware.RequestLog(c).Info("会话已删除(session=%s)", sessionID)
+	c.Status(http.StatusNoContent)
+}
+
+// ResetSessionHandler 清空会话历史，保留标题与角色 POST /sessions/:id/reset
+func ResetSessionHandler(c *gin.Context) {
+	sessionID := c.Param("id")
+	meta, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c))
+	if err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+
+	// 持有对话锁，避免进行中的对话在重置后写回旧轮次的消息
+	unlock, err := services.LockTurn(c.Request.Context(), sessionID)
+	if err != nil {
+		respondTurnError(c, sessionID, err)
+		return
+	}
+	defer unlock()
+
+	role := services.ResolveRole(meta.Role)
+	services.ResetSession(c.Request.Context(), sessionID, role.ID, role.SystemPrompt)
+	middleware.RequestLog(c).Info("会话已重置(session=%s, role=%s)", sessionID, role.ID)
+
+	meta, _ = services.GetSessionMeta(sessionID)
+	c.JSON(http.StatusOK, gin.H{"session": meta})
+}
+
+// UpdateSessionHandler 修改会话标题或角色 PATCH /sessions/:id
+func UpdateSessionHandler(c *gin.Context) {
+	sessionID := c.Param("id")
+	var req struct {
+		Title *string `json:"title"`
+		Role  *string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.RequestLog(c).Warning("请求参数解析失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+
+	if _, err := services.AuthorizeSession(sessionID, middleware.CurrentPrincipal(c)); err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+
+	patch := models.SessionPatch{Title: req.Title}
+	if req.Role != nil {
+		role, ok := services.GetRole(*req.Role)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "未知角色: " + *req.Role})
+			return
+		}
+		patch.Role = &role.ID
+		patch.SystemPrompt = &role.SystemPrompt
+
+		// 切换角色会替换历史中的system提示词，须持有对话锁
+		unlock, err := services.LockTurn(c.Request.Context(), sessionID)
+		if err != nil {
+			respondTurnError(c, sessionID, err)
+			return
+		}
+		defer unlock()
+	}
+
+	if err := services.UpdateSession(sessionID, patch); err != nil {
+		respondSessionError(c, sessionID, err)
+		return
+	}
+
+	meta, _ := services.GetSessionMeta(sessionID)
+	c.JSON(http.StatusOK, gin.H{"session": meta})
+}
+
+// respondSessionError 将会话操作错误映射为HTTP响应
+func respondSessionError(c *gin.Context, sessionID string, err error) {
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrSessionForbidden) {
+		middleware.RequestLog(c).Warning("拒绝跨用户访问会话(session=%s)", sessionID)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": codeForbidden})
+		return
+	}
+	middleware.RequestLog(c).Error("会话操作失败(session=%s): %v", sessionID, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"AiDemo/config"
+	"AiDemo/services"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 除图片外，请求中其他字段允许的大小
+const maxChatFieldsBytes = 1 << 20
+
+// limitChatBody 限制对话请求体的大小：其他字段加上最多 MaxImages 张 base64 编码的图片
+func limitChatBody(c *gin.Context) {
+	limit := int64(maxChatFieldsBytes + config.C.Attachments.MaxImages*services.MaxImageBytes()*4/3)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+}
+
+// bindChatRequest 解析对话请求：JSON 中图片为 base64 字符串，
+// multipart/form-data 中文本字段同名，图片为 images 文件字段，生成参数以JSON放在 params 字段。
+// 图片在这里完成大小与类型校验
+func bindChatRequest(c *gin.Context, req *chatRequest) error {
+	maxImages := config.C.Attachments.MaxImages
+	limitChatBody(c)
+
+	if !strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		if err := c.ShouldBindJSON(req); err != nil {
+			return err
+		}
+		if len(req.Images) > maxImages {
+			return fmt.Errorf("最多附带 %d 张图片", maxImages)
+		}
+		for i, s := range req.Images {
+			img, err := services.DecodeImage(s)
+			if err != nil {
+				return fmt.Errorf("images[%d]: %v", i, err)
+			}
+			req.images = append(req.images, img)
+		}
+		return nil
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return err
+	}
+	req.Message = c.PostForm("message")
+	req.Role = c.PostForm("role")
+	req.SessionID = c.PostForm("session_id")
+	req.Provider = c.PostForm("provider")
+	if params := c.PostForm("params"); params != "" {
+		if err := json.Unmarshal([]byte(params), &req.GenerationParams); err != nil {
+			return fmt.Errorf("params 不是合法的JSON: %v", err)
+		}
+	}
+
+	files := form.File["images"]
+	if len(files) > maxImages {
+		return fmt.Errorf("最多附带 %d 张图片", maxImages)
+	}
+	for _, fh := range files {
+		if fh.Size > int64(services.MaxImageBytes()) {
+			return fmt.Errorf("%s: 图片大小超过 %dMB", fh.Filename, config.C.Attachments.MaxImageMB)
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		img, err := services.ValidateImage(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fh.Filename, err)
+		}
+		req.images = append(req.images, img)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"AiDemo/middleware"
+	"AiDemo/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpstreamStatsHandler 返回各提供方的上游并发、排队深度与等待时间统计，仅管理员可用
+// GET /upstream/stats
+func UpstreamStatsHandler(c *gin.Context) {
+	if !middleware.CurrentPrincipal(c).Admin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员可查询上游调度统计", "code": codeForbidden})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"providers": services.UpstreamStats()})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"AiDemo/middleware"
+	"AiDemo/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UsageHandler 查询token用量与费用 GET /usage
+// 查询参数：from、to（YYYY-MM-DD，含边界）、role；指定 session_id 时返回该会话的累计用量。
+// 汇总用量仅管理员可查
+func UsageHandler(c *gin.Context) {
+	p := middleware.CurrentPrincipal(c)
+	if sessionID := c.Query("session_id"); sessionID != "" {
+		meta, err := services.AuthorizeSession(sessionID, p)
+		if err != nil {
+			respondSessionError(c, sessionID, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"session_id": sessionID, "usage": meta.Usage})
+		return
+	}
+
+	if !p.Admin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员可查询汇总用量", "code": codeForbidden})
+		return
+	}
+
+	q := services.UsageQuery{From: c.Query("from"), To: c.Query("to"), Role: c.Query("role")}
+	for _, d := range []string{q.From, q.To} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式应为 YYYY-MM-DD: " + d, "code": codeBadRequest})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, services.QueryUsage(q))
+}
@@ -1,43 +1,74 @@
 package init
 
 import (
+	"AiDemo/config"
 	"AiDemo/utils"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
+// 日志级别名称 -> 级别常量
+var logLevels = map[string]int{
+	"DEBUG":   utils.DEBUG,
+	"INFO":    utils.INFO,
+	"WARNING": utils.WARNING,
+	"ERROR":   utils.ERROR,
+}
+
 // InitLog 初始化日志系统
-func InitLog() error {
+func InitLog(cfg config.LogConfig) error {
 	// 创建日志目录
-	logDir := "./logs"
+	logDir := cfg.Dir
 	err := os.MkdirAll(logDir, 0755)
 	if err != nil {
 		return fmt.Errorf("创建日志目录失败: %w", err)
 	}
 
-	// 设置日志文件路径，使用基本文件名，让轮转功能添加日期
+	// 轮转策略：按天或小时、按大小切换文件，轮转出的文件在后台压缩与清理。
+	// 须在设置日志文件之前设置，启动后的首个文件同样按周期、大小与序号选取
+	interval := utils.RotateDaily
+	if cfg.RotateInterval == "hourly" {
+		interval = utils.RotateHourly
+	}
+	utils.SetRotateOptions(utils.RotateOptions{
+		Interval: interval,
+		MaxSize:  int64(cfg.MaxSizeMB) << 20,
+		Compress: cfg.Compress,
+		MaxAge:   time.Duration(cfg.MaxAgeDays) * 24 * time.Hour,
+		MaxTotal: int64(cfg.MaxTotalMB) << 20,
+	})
+
+	// 设置日志文件路径，使用基本文件名，由轮转策略添加周期与序号
 	logFile := filepath.Join(logDir, "app.log")
 	err = utils.SetLogFile(logFile)
 	if err != nil {
 		utils.Error("设置日志文件失败: %v", err)
 		// 即使设置文件失败，也继续使用控制台输出
 	}
-
-	// 启用日志轮转（按天轮转）
 	utils.EnableRotate()
 
-	// 设置日志级别（可根据环境变量或配置文件调整）
-	utils.SetLevel(utils.INFO)
+	// 设置日志级别
+	utils.SetLevel(logLevels[strings.ToUpper(cfg.Level)])
 
-	// 启用异步日志写入（缓冲区大小为1000，刷新间隔为3秒）
-	utils.EnableAsync(1000, 3*time.Second)
+	// 启用异步日志写入（缓冲区大小为0时保持同步写入）
+	if cfg.AsyncBufferSize > 0 {
+		utils.EnableAsync(cfg.AsyncBufferSize, time.Duration(cfg.FlushInterval)*time.Second)
+	}
+
+	// 设置日志格式
+	if cfg.Format == "json" {
+		utils.SetFormat(utils.JsonFormat)
+	}
 
-	// 设置日志格式（默认为文本格式，可选JSON格式）
-	// utils.SetFormat(utils.JsonFormat) // 取消注释启用JSON格式
+	// 第三方库经标准库 log 或 log/slog 输出的日志同样写入日志文件
+	if cfg.RouteStdLog {
+		utils.RouteStdLog()
+	}
 
-	utils.Info("日志系统初始化完成，已启用按天轮转和异步写入")
+	utils.Info("日志系统初始化完成(level=%s, format=%s, async_buffer=%d)", cfg.Level, cfg.Format, cfg.AsyncBufferSize)
 	return nil
 }
 
@@ -4,32 +4,72 @@ import (
 	"AiDemo/config"
 	"AiDemo/handlers"
 	initPkg "AiDemo/init"
+	"AiDemo/middleware"
+	"AiDemo/services"
 	"AiDemo/utils"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// 加载配置（默认值 -> 配置文件 -> 环境变量 -> 命令行参数）
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+
 	// 初始化日志系统
-	if err := initPkg.InitLog(); err != nil {
+	if err := initPkg.InitLog(cfg.Log); err != nil {
 		log.Fatalf("日志系统初始化失败: %v", err)
 	}
 	defer initPkg.CloseLog()
+	utils.Info("配置加载完成")
 
-	// 加载配置
-	utils.Info("正在加载配置...")
-	err := config.LoadEnv()
-	if err != nil {
-		utils.Fatal("加载配置失败: %v", err)
+	// 注册模型提供方
+	services.InitProviders()
+
+	// 注册内置工具（角色文件引用的工具须先注册）
+	services.InitTools()
+
+	// 加载角色定义
+	if err := services.LoadRoles(cfg.Roles.Dir); err != nil {
+		utils.Fatal("加载角色失败: %v", err)
 		return
 	}
-	utils.Info("配置加载完成")
+	if cfg.Roles.ReloadInterval > 0 {
+		stopWatch := services.WatchRoles(time.Duration(cfg.Roles.ReloadInterval) * time.Second)
+		defer stopWatch()
+	}
+
+	// 初始化用量账本
+	if err := services.InitUsage(cfg.Usage.File); err != nil {
+		utils.Fatal("用量账本初始化失败: %v", err)
+		return
+	}
+	defer services.CloseUsage()
+
+	// 初始化会话存储
+	if err := services.InitSessionStore(); err != nil {
+		utils.Fatal("会话存储初始化失败: %v", err)
+		return
+	}
+	defer services.CloseSessionStore()
 
 	// 创建 Gin 引擎
 	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
+	r := gin.New()
+	if cfg.Log.RouteGin {
+		// 访问日志与 panic 恢复信息都经日志系统输出
+		gin.DefaultErrorWriter = utils.Writer(utils.ERROR)
+		r.Use(middleware.AccessLog(), gin.Recovery())
+	} else {
+		// 与 gin.Default() 相同
+		r.Use(gin.Logger(), gin.Recovery())
+	}
 
 	// 静态文件（前端页面）
 	r.Static("/web", "./web")
@@ -40,13 +80,65 @@ func main() {
 		c.Redirect(http.StatusFound, "/web/index.html")
 	})
 
-	// 仅保留聊天路由
-	r.POST("/chat", handlers.ChatHandler)
+	// API路由：每个请求分配请求ID并带入日志；启用认证时需携带 API Key 或签名令牌
+	api := r.Group("")
+	api.Use(middleware.RequestLogger())
+	if cfg.Auth.Enabled {
+		api.Use(middleware.Auth())
+		utils.Info("API认证已启用(%d 个静态key, 令牌: %v)", len(cfg.Auth.Keys), cfg.Auth.TokenSecret != "")
+	}
+	api.GET("/auth/me", handlers.WhoAmIHandler)
+	api.POST("/auth/token", handlers.IssueTokenHandler)
+
+	// 聊天路由（按配置限流，与 OpenAI 兼容接口共享额度）
+	limited := []gin.HandlerFunc{}
+	if cfg.RateLimit.Enabled {
+		limited = append(limited, middleware.RateLimit(middleware.RateLimitOptions{
+			KeyBy:             cfg.RateLimit.KeyBy,
+			RequestsPerMinute: cfg.RateLimit.RequestsPerMinute,
+			Burst:             cfg.RateLimit.Burst,
+			TokensPerDay:      cfg.RateLimit.TokensPerDay,
+		}))
+		utils.Info("对话接口限流已启用(key_by=%s)", cfg.RateLimit.KeyBy)
+	}
+	chat := api.Group("/chat", limited...)
+	chat.POST("", handlers.ChatHandler)
+	chat.POST("/stream", handlers.ChatStreamHandler)
+
+	// OpenAI 兼容接口
+	v1 := api.Group("/v1")
+	v1.GET("/models", handlers.OpenAIModelsHandler)
+	v1.POST("/chat/completions", append(limited, handlers.OpenAIChatCompletionsHandler)...)
+	api.GET("/roles", handlers.ListRolesHandler)
+	api.GET("/usage", handlers.UsageHandler)
+	api.GET("/upstream/stats", handlers.UpstreamStatsHandler)
+
+	// 会话管理路由（仅能访问自己的会话，管理员不受限制）
+	api.GET("/sessions", handlers.ListSessionsHandler)
+	api.GET("/sessions/:id/messages", handlers.GetSessionMessagesHandler)
+	api.GET("/sessions/:id/attachments/:name", handlers.GetAttachmentHandler)
+	api.GET("/sessions/:id/export", handlers.ExportSessionHandler)
+	api.POST("/sessions/import", handlers.ImportSessionHandler)
+	api.DELETE("/sessions/:id", handlers.DeleteSessionHandler)
+	api.POST("/sessions/:id/reset", handlers.ResetSessionHandler)
+	api.PATCH("/sessions/:id", handlers.UpdateSessionHandler)
+	api.POST("/sessions/:id/regenerate", append(limited, handlers.RegenerateHandler)...)
+	api.PUT("/sessions/:id/messages/:index", append(limited, handlers.EditMessageHandler)...)
+
+	// 文档检索路由：会话私有文档与共享知识库（共享知识库仅管理员可修改），
+	// 上传时的向量化用量与对话共享限流与每日额度
+	api.POST("/sessions/:id/documents", append(limited, handlers.UploadSessionDocumentHandler)...)
+	api.GET("/sessions/:id/documents", handlers.ListSessionDocumentsHandler)
+	api.DELETE("/sessions/:id/documents/:doc_id", handlers.DeleteSessionDocumentHandler)
+	api.GET("/knowledge", handlers.ListKnowledgeBasesHandler)
+	api.POST("/knowledge/:name/documents", append(limited, handlers.UploadKnowledgeDocumentHandler)...)
+	api.GET("/knowledge/:name/documents", handlers.ListKnowledgeDocumentsHandler)
+	api.DELETE("/knowledge/:name/documents/:doc_id", handlers.DeleteKnowledgeDocumentHandler)
 	utils.Info("API路由已注册")
 
-	utils.Info("🚀 服务已启动，请在浏览器访问: http://localhost:8080")
+	utils.Info("🚀 服务已启动，请在浏览器访问: http://localhost%s", cfg.Server.Addr)
 
-	err = r.Run(":8080")
+	err = r.Run(cfg.Server.Addr)
 	if err != nil {
 		utils.Fatal("服务启动失败: %v", err)
 		return
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"AiDemo/models"
+	"AiDemo/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey 认证通过后调用方身份在 gin.Context 中的键
+const principalKey = "auth.principal"
+
+// Auth 返回认证中间件：校验 X-API-Key 或 Authorization: Bearer 携带的凭证，
+// 失败时返回401，成功后可通过 CurrentPrincipal 取得调用方
+func Auth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := services.Authenticate(credential(c))
+		if err != nil {
+			RequestLog(c).Warning("认证失败(ip=%s, path=%s): %v", c.ClientIP(), c.Request.URL.Path, err)
+			c.Header("WWW-Authenticate", `Bearer realm="AiDemo"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "未认证: " + err.Error(), "code": "unauthorized"})
+			return
+		}
+		c.Set(principalKey, p)
+		c.Next()
+	}
+}
+
+// CurrentPrincipal 返回当前请求的调用方，未启用认证时为 models.Anonymous
+func CurrentPrincipal(c *gin.Context) models.Principal {
+	if v, ok := c.Get(principalKey); ok {
+		return v.(models.Principal)
+	}
+	return models.Anonymous
+}
+
+// Authenticated 判断当前请求是否经过认证，未启用认证时为false
+func Authenticated(c *gin.Context) bool {
+	_, ok := c.Get(principalKey)
+	return ok
+}
+
+// credential 提取请求携带的凭证，优先 X-API-Key
+func credential(c *gin.Context) string {
+	if k := c.GetHeader("X-API-Key"); k != "" {
+		return k
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return ""
+}
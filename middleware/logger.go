@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"AiDemo/utils"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader 携带请求ID的请求头与响应头
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDRe 客户端传入的请求ID须满足的格式，不满足时重新生成
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestLogger 返回请求日志中间件：为每个请求分配请求ID（沿用客户端传入的合法 X-Request-ID），
+// 写入响应头，并把附带 request_id 字段的记录器放入请求的ctx，后续经 utils.FromContext 取用
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDRe.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		ctx := utils.NewContext(c.Request.Context(), utils.With("request_id", id))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// RequestLog 返回当前请求的记录器
+func RequestLog(c *gin.Context) *utils.Logger {
+	return utils.FromContext(c.Request.Context())
+}
+
+// AddLogFields 为当前请求的记录器附加字段，此后经请求ctx派生的日志均带有这些字段
+func AddLogFields(c *gin.Context, args ...interface{}) *utils.Logger {
+	ctx := utils.ContextWith(c.Request.Context(), args...)
+	c.Request = c.Request.WithContext(ctx)
+	return utils.FromContext(ctx)
+}
+
+// AccessLog 返回访问日志中间件，替代 gin 自带的 Logger：请求结束后经请求的记录器输出
+// 方法、路径、状态码与耗时，状态码为5xx时记为 ERROR，4xx 时记为 WARNING
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+
+		status := c.Writer.Status()
+		log := RequestLog(c).With(
+			"method", c.Request.Method,
+			"path", path,
+			"status", status,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		)
+		switch {
+		case status >= http.StatusInternalServerError:
+			log.Error("%s %s %d", c.Request.Method, path, status)
+		case status >= http.StatusBadRequest:
+			log.Warning("%s %s %d", c.Request.Method, path, status)
+		default:
+			log.Info("%s %s %d", c.Request.Method, path, status)
+		}
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
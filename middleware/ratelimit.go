@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"AiDemo/models"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 限流键的来源
+const (
+	KeyByAPIKey  = "api_key" // 已认证的调用方，未启用认证时为 X-API-Key 或 Authorization: Bearer
+	KeyBySession = "session" // 请求头 X-Session-ID 或请求体中的 session_id
+	KeyByIP      = "ip"      // 客户端IP
+)
+
+// usedTokensKey 处理器通过 ChargeTokens 写入本次请求消耗的token数
+const usedTokensKey = "ratelimit.used_tokens"
+
+// 读取请求体中 session_id 时的最大字节数
+const maxPeekBody = 1 << 20
+
+// RateLimitOptions 限流参数
+type RateLimitOptions struct {
+	KeyBy             string // api_key、session 或 ip，取不到时回退为ip
+	RequestsPerMinute int    // 每分钟请求数，0表示不限制
+	Burst             int    // 令牌桶容量，0表示与 RequestsPerMinute 相同
+	TokensPerDay      int    // 每日token额度，0表示不限制
+	Store             RateLimitStore
+}
+
+// ChargeTokens 记录本次请求消耗的token数，由限流中间件在请求结束后计入每日额度
+func ChargeTokens(c *gin.Context, n int) {
+	c.Set(usedTokensKey, c.GetInt(usedTokensKey)+n)
+}
+
+// RateLimit 返回按客户端限制请求频率与每日token额度的中间件
+func RateLimit(opts RateLimitOptions) gin.HandlerFunc {
+	if opts.Store == nil {
+		opts.Store = NewMemoryRateLimitStore()
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = opts.RequestsPerMinute
+	}
+	rate := float64(opts.RequestsPerMinute) / 60
+
+	return func(c *gin.Context) {
+		key := limitKey(c, opts.KeyBy)
+		now := time.Now()
+		day := now.Format("2006-01-02")
+
+		if opts.RequestsPerMinute > 0 {
+			ok, remaining, wait := opts.Store.Take("rpm:"+key, rate, opts.Burst, now)
+			c.Header("X-RateLimit-Limit", strconv.Itoa(opts.RequestsPerMinute))
+			c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			if !ok {
+				secs := int(math.Ceil(wait.Seconds()))
+				RequestLog(c).Warning("请求频率超限(key=%s)，需等待 %d 秒", key, secs)
+				c.Header("Retry-After", strconv.Itoa(secs))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+					"error":       "请求过于频繁，请稍后再试",
+					"code":        "rate_limited",
+					"retry_after": secs,
+				})
+				return
+			}
+		}
+
+		if opts.TokensPerDay > 0 {
+			used := opts.Store.DailyTokens("tpd:"+key, day)
+			remaining := opts.TokensPerDay - used
+			if remaining < 0 {
+				remaining = 0
+			}
+			c.Header("X-Quota-Tokens-Limit", strconv.Itoa(opts.TokensPerDay))
+			c.Header("X-Quota-Tokens-Remaining", strconv.Itoa(remaining))
+			if remaining == 0 {
+				secs := secondsUntilTomorrow(now)
+				RequestLog(c).Warning("每日token额度已用尽(key=%s)", key)
+				c.Header("Retry-After", strconv.Itoa(secs))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+					"error":       "今日token额度已用尽",
+					"code":        "quota_exceeded",
+					"retry_after": secs,
+				})
+				return
+			}
+		}
+
+		c.Next()
+
+		if opts.TokensPerDay > 0 {
+			if n := c.GetInt(usedTokensKey); n > 0 {
+				opts.Store.AddDailyTokens("tpd:"+key, day, n)
+			}
+		}
+	}
+}
+
+// limitKey 按配置提取限流键，取不到时回退为客户端IP
+func limitKey(c *gin.Context, keyBy string) string {
+	switch keyBy {
+	case KeyByAPIKey:
+		// 已认证时按调用方计数，同一调用方的多个key与令牌共享额度
+		if p, ok := c.Get(principalKey); ok {
+			return "principal:" + p.(models.Principal).ID
+		}
+		if k := credential(c); k != "" {
+			return "key:" + k
+		}
+	case KeyBySession:
+		if id := c.GetHeader("X-Session-ID"); id != "" {
+			return "session:" + id
+		}
+		// /sessions/:id 下的接口（重新生成、编辑消息、上传文档）取路径中的会话ID
+		if id := c.Param("id"); id != "" {
+			return "session:" + id
+		}
+		if id := peekSessionID(c); id != "" {
+			return "session:" + id
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// peekSessionID 读取JSON请求体中的 session_id，并还原请求体供后续处理器使用
+func peekSessionID(c *gin.Context) string {
+	if c.Request.Body == nil || !strings.Contains(c.ContentType(), "json") {
+		return ""
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPeekBody))
+	if err != nil {
+		return ""
+	}
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), c.Request.Body))
+
+	var body struct {
+		SessionID string `json:"session_id"`
+	}
+	if json.Unmarshal(data, &body) != nil {
+		return ""
+	}
+	return body.SessionID
+}
+
+func secondsUntilTomorrow(now time.Time) int {
+	y, m, d := now.Date()
+	tomorrow := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+	return int(math.Ceil(tomorrow.Sub(now).Seconds()))
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitStore 限流状态存储接口，默认为内存实现，多实例部署时可替换为共享存储
+type RateLimitStore interface {
+	// Take 从key的令牌桶中取一个令牌。rate为每秒补充的令牌数，burst为桶容量。
+	// 返回是否成功、剩余令牌数，以及失败时需要等待的时间
+	Take(key string, rate float64, burst int, now time.Time) (ok bool, remaining int, retryAfter time.Duration)
+	// DailyTokens 返回key在day当天已消耗的token数
+	DailyTokens(key string, day string) int
+	// AddDailyTokens 累加key在day当天消耗的token数
+	AddDailyTokens(key string, day string, n int)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryRateLimitStore 内存版限流状态存储
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	daily   map[string]map[string]int // day -> key -> tokens
+	takes   int
+}
+
+// 每隔多少次 Take 清理一次空闲令牌桶
+const bucketSweepEvery = 1000
+
+// NewMemoryRateLimitStore 创建内存版限流状态存储
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]*tokenBucket),
+		daily:   make(map[string]map[string]int),
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, rate float64, burst int, now time.Time) (bool, int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takes++
+	if s.takes%bucketSweepEvery == 0 {
+		s.sweep(rate, burst, now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+
+	// 按流逝时间补充令牌
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, int(b.tokens), 0
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, 0, wait
+}
+
+// sweep 清理已补满的令牌桶，调用方需持有锁
+func (s *MemoryRateLimitStore) sweep(rate float64, burst int, now time.Time) {
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func (s *MemoryRateLimitStore) DailyTokens(key string, day string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.daily[day][key]
+}
+
+func (s *MemoryRateLimitStore) AddDailyTokens(key string, day string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.daily[day]; !ok {
+		// 进入新的一天，丢弃之前的计数
+		s.daily = map[string]map[string]int{day: {}}
+	}
+	s.daily[day][key] += n
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreTake(t *testing.T) {
+	start := time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC)
+	type take struct {
+		key           string
+		at            time.Duration // 相对start的时间
+		wantOK        bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		takes []take
+	}{
+		{
+			name: "突发容量用尽后拒绝", rate: 1, burst: 3,
+			takes: []take{
+				{key: "a", wantOK: true, wantRemaining: 2},
+				{key: "a", wantOK: true, wantRemaining: 1},
+				{key: "a", wantOK: true, wantRemaining: 0},
+				{key: "a", wantOK: false, wantRetry: time.Second},
+			},
+		},
+		{
+			name: "按流逝时间补充", rate: 2, burst: 2,
+			takes: []take{
+				{key: "a", wantOK: true, wantRemaining: 1},
+				{key: "a", wantOK: true, wantRemaining: 0},
+				{key: "a", at: 250 * time.Millisecond, wantOK: false, wantRetry: 250 * time.Millisecond},
+				{key: "a", at: 500 * time.Millisecond, wantOK: true, wantRemaining: 0},
+			},
+		},
+		{
+			name: "补充不超过桶容量", rate: 1, burst: 2,
+			takes: []take{
+				{key: "a", wantOK: true, wantRemaining: 1},
+				{key: "a", at: time.Hour, wantOK: true, wantRemaining: 1},
+			},
+		},
+		{
+			name: "不同key互不影响", rate: 1, burst: 1,
+			takes: []take{
+				{key: "a", wantOK: true},
+				{key: "a", wantOK: false, wantRetry: time.Second},
+				{key: "b", wantOK: true},
+			},
+		},
+		{
+			name: "时钟回退时不补充", rate: 1, burst: 1,
+			takes: []take{
+				{key: "a", at: time.Minute, wantOK: true},
+				{key: "a", wantOK: false, wantRetry: time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryRateLimitStore()
+			for i, tk := range tt.takes {
+				ok, remaining, retry := s.Take(tk.key, tt.rate, tt.burst, start.Add(tk.at))
+				if ok != tk.wantOK || remaining != tk.wantRemaining || retry != tk.wantRetry {
+					t.Errorf("第%d次 Take(%s) = %v, %d, %v, want %v, %d, %v",
+						i+1, tk.key, ok, remaining, retry, tk.wantOK, tk.wantRemaining, tk.wantRetry)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStoreSweep(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	now := time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < bucketSweepEvery-1; i++ {
+		s.Take("idle", 1, 1, now)
+	}
+	s.Take("busy", 1, 1, now.Add(time.Second))
+	s.Take("busy", 1, 1, now.Add(time.Second))
+	// 第 bucketSweepEvery 次 Take 时清理，此时idle已补满被删除；busy 随后被取空，不受影响
+	if _, ok := s.buckets["idle"]; ok {
+		t.Error("已补满的令牌桶未被清理")
+	}
+	if _, ok := s.buckets["busy"]; !ok {
+		t.Error("未补满的令牌桶被清理")
+	}
+}
+
+func TestMemoryRateLimitStoreDailyTokens(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	s.AddDailyTokens("a", "2024-05-20", 100)
+	s.AddDailyTokens("a", "2024-05-20", 50)
+	s.AddDailyTokens("b", "2024-05-20", 7)
+
+	tests := []struct {
+		key, day string
+		want     int
+	}{
+		{key: "a", day: "2024-05-20", want: 150},
+		{key: "b", day: "2024-05-20", want: 7},
+		{key: "c", day: "2024-05-20", want: 0},
+		{key: "a", day: "2024-05-21", want: 0},
+	}
+	for _, tt := range tests {
+		if got := s.DailyTokens(tt.key, tt.day); got != tt.want {
+			t.Errorf("DailyTokens(%s, %s) = %d, want %d", tt.key, tt.day, got, tt.want)
+		}
+	}
+
+	// 进入新的一天后丢弃之前的计数
+	s.AddDailyTokens("a", "2024-05-21", 1)
+	if got := s.DailyTokens("a", "2024-05-20"); got != 0 {
+		t.Errorf("新的一天后旧计数 = %d, want 0", got)
+	}
+	if got := s.DailyTokens("a", "2024-05-21"); got != 1 {
+		t.Errorf("DailyTokens = %d, want 1", got)
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// KnowledgeDocument 已上传并完成向量化的文档
+type KnowledgeDocument struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Source    string    `json:"source,omitempty"` // 上传时的文件名
+	Size      int       `json:"size"`             // 文本字节数
+	Chunks    int       `json:"chunks"`           // 切分出的片段数
+	Model     string    `json:"model"`            // 向量化使用的模型
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KnowledgeBaseInfo 共享知识库概况
+type KnowledgeBaseInfo struct {
+	Name      string `json:"name"`
+	Documents int    `json:"documents"`
+	Chunks    int    `json:"chunks"`
+}
+
+// Citation 注入提示词的文档片段，Index 与回复中的 [n] 标注对应
+type Citation struct {
+	Index      int     `json:"index"`
+	Base       string  `json:"base"` // 来源知识库，会话文档为 "session"
+	DocumentID string  `json:"document_id"`
+	Title      string  `json:"title"`
+	Chunk      int     `json:"chunk"` // 片段在文档中的序号
+	Score      float64 `json:"score"` // 余弦相似度
+	Text       string  `json:"text"`
+}
+
+// EmbeddingRequest OpenAI 兼容的 embeddings 请求体
+type EmbeddingRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	EncodingFormat string   `json:"encoding_format,omitempty"`
+}
+
+// EmbeddingResponse embeddings 响应体
+type EmbeddingResponse struct {
+	Model string `json:"model"`
+	Data  []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage *Usage `json:"usage,omitempty"`
+}
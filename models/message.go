@@ -1,19 +1,270 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message 一条对话消息。Parts 非空时为多模态消息，序列化为 content 数组，
+// 此时 Content 仅保存其中的文本部分，供估算token、生成标题等使用
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string        `json:"role"`
+	Content string        `json:"content"`
+	Parts   []ContentPart `json:"-"`
+	// ToolCalls 助手请求调用的工具，仅 role=assistant 时出现
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID 工具结果对应的调用ID，仅 role=tool 时出现
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// CreatedAt 写入会话的时间，发送给上游前清空
+	CreatedAt time.Time `json:"-"`
+}
+
+// 内容片段类型
+const (
+	PartText  = "text"
+	PartImage = "image_url"
+)
+
+// ContentPart 多模态消息的一个片段
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL 图片地址：http(s) 地址、data URL，或会话内附件的 attachment:// 引用
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// NewMultipartMessage 创建由文本与图片片段组成的消息
+func NewMultipartMessage(role string, parts []ContentPart) Message {
+	return Message{Role: role, Content: partsText(parts), Parts: parts}
+}
+
+// HasImages 判断消息是否包含图片
+func (m Message) HasImages() bool {
+	for _, p := range m.Parts {
+		if p.Type == PartImage {
+			return true
+		}
+	}
+	return false
+}
+
+// messageJSON 用于 Message 的自定义序列化，避免递归调用
+type messageJSON struct {
+	Role       string          `json:"role"`
+	Content    json.RawMessage `json:"content"`
+	ToolCalls  []ToolCall      `json:"tool_calls,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	CreatedAt  *time.Time      `json:"created_at,omitempty"`
+}
+
+// MarshalJSON 有 Parts 时 content 输出为片段数组，否则为字符串
+func (m Message) MarshalJSON() ([]byte, error) {
+	var content interface{} = m.Content
+	if len(m.Parts) > 0 {
+		content = m.Parts
+	}
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	out := messageJSON{Role: m.Role, Content: raw, ToolCalls: m.ToolCalls, ToolCallID: m.ToolCallID}
+	if !m.CreatedAt.IsZero() {
+		out.CreatedAt = &m.CreatedAt
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON content 可以是字符串、片段数组或 null
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var raw messageJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = Message{Role: raw.Role, ToolCalls: raw.ToolCalls, ToolCallID: raw.ToolCallID}
+	if raw.CreatedAt != nil {
+		m.CreatedAt = *raw.CreatedAt
+	}
+	if len(raw.Content) == 0 || string(raw.Content) == "null" {
+		return nil
+	}
+	if raw.Content[0] == '"' {
+		return json.Unmarshal(raw.Content, &m.Content)
+	}
+	if err := json.Unmarshal(raw.Content, &m.Parts); err != nil {
+		return fmt.Errorf("content 必须是字符串或内容片段数组: %w", err)
+	}
+	m.Content = partsText(m.Parts)
+	return nil
+}
+
+// partsText 按顺序拼接所有文本片段
+func partsText(parts []ContentPart) string {
+	var texts []string
+	for _, p := range parts {
+		if p.Type == PartText && p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ToolCall 模型发起的一次工具调用
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"` // 目前只有 function
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall 工具名与JSON编码的参数
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Tool 随请求发送给模型的工具定义
+type Tool struct {
+	Type     string      `json:"type"` // 目前只有 function
+	Function FunctionDef `json:"function"`
+}
+
+// FunctionDef 工具的名称、说明与参数的 JSON Schema
+type FunctionDef struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// GenerationParams 生成参数，nil 表示使用模型默认值
+type GenerationParams struct {
+	Temperature      *float64 `json:"temperature,omitempty" yaml:"temperature"`
+	TopP             *float64 `json:"top_p,omitempty" yaml:"top_p"`
+	MaxTokens        *int     `json:"max_tokens,omitempty" yaml:"max_tokens"`
+	Stop             []string `json:"stop,omitempty" yaml:"stop"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty" yaml:"frequency_penalty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty" yaml:"presence_penalty"`
+	Seed             *int64   `json:"seed,omitempty" yaml:"seed"`
+}
+
+// 生成参数的取值范围
+const (
+	MaxStopSequences = 4
+	maxStopLength    = 64
+)
+
+// Merge 以override中已设置的字段覆盖p，返回合并后的参数
+func (p GenerationParams) Merge(override GenerationParams) GenerationParams {
+	merged := p
+	if override.Temperature != nil {
+		merged.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		merged.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		merged.MaxTokens = override.MaxTokens
+	}
+	if override.Stop != nil {
+		merged.Stop = override.Stop
+	}
+	if override.FrequencyPenalty != nil {
+		merged.FrequencyPenalty = override.FrequencyPenalty
+	}
+	if override.PresencePenalty != nil {
+		merged.PresencePenalty = override.PresencePenalty
+	}
+	if override.Seed != nil {
+		merged.Seed = override.Seed
+	}
+	return merged
+}
+
+// Validate 校验参数范围，maxTokensLimit 为 max_tokens 允许的上限，返回所有不合法字段的说明
+func (p GenerationParams) Validate(maxTokensLimit int) []string {
+	var problems []string
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		problems = append(problems, fmt.Sprintf("temperature 必须在 [0, 2] 之间: %v", *p.Temperature))
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		problems = append(problems, fmt.Sprintf("top_p 必须在 (0, 1] 之间: %v", *p.TopP))
+	}
+	if p.MaxTokens != nil && (*p.MaxTokens < 1 || *p.MaxTokens > maxTokensLimit) {
+		problems = append(problems, fmt.Sprintf("max_tokens 必须在 [1, %d] 之间: %d", maxTokensLimit, *p.MaxTokens))
+	}
+	if len(p.Stop) > MaxStopSequences {
+		problems = append(problems, fmt.Sprintf("stop 最多 %d 个", MaxStopSequences))
+	}
+	for _, s := range p.Stop {
+		if s == "" || len(s) > maxStopLength {
+			problems = append(problems, fmt.Sprintf("stop 中的每一项长度必须在 [1, %d] 字节之间", maxStopLength))
+			break
+		}
+	}
+	if p.FrequencyPenalty != nil && (*p.FrequencyPenalty < -2 || *p.FrequencyPenalty > 2) {
+		problems = append(problems, fmt.Sprintf("frequency_penalty 必须在 [-2, 2] 之间: %v", *p.FrequencyPenalty))
+	}
+	if p.PresencePenalty != nil && (*p.PresencePenalty < -2 || *p.PresencePenalty > 2) {
+		problems = append(problems, fmt.Sprintf("presence_penalty 必须在 [-2, 2] 之间: %v", *p.PresencePenalty))
+	}
+	if p.Seed != nil && *p.Seed < 0 {
+		problems = append(problems, fmt.Sprintf("seed 不能为负数: %d", *p.Seed))
+	}
+	return problems
 }
 
 type RequestBody struct {
 	Model    string    `json:"model"`
 	Messages []Message `json:"messages"`
+	Stream   bool      `json:"stream,omitempty"`
+	// StreamOptions 流式请求时要求上游在最后一个片段中返回用量
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	Tools         []Tool         `json:"tools,omitempty"`
+	GenerationParams
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type Choice struct {
-	Message Message `json:"message"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason,omitempty"`
 }
 
 type ResponseBody struct {
 	Choices []Choice `json:"choices"`
+	Usage   *Usage   `json:"usage,omitempty"`
+}
+
+// StreamChoice 流式响应中的增量片段
+type StreamChoice struct {
+	Delta        StreamDelta `json:"delta"`
+	FinishReason string      `json:"finish_reason,omitempty"`
+}
+
+// StreamDelta 流式增量，工具调用按 index 分多个片段到达，参数需依次拼接
+type StreamDelta struct {
+	Role      string           `json:"role,omitempty"`
+	Content   string           `json:"content"`
+	ToolCalls []StreamToolCall `json:"tool_calls,omitempty"`
+}
+
+// StreamToolCall 工具调用的增量片段
+type StreamToolCall struct {
+	Index    int          `json:"index"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+// StreamResponse 流式响应中每个 data: 行对应的结构
+type StreamResponse struct {
+	Choices []StreamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"`
 }
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessageJSONRoundTrip(t *testing.T) {
+	created := time.Date(2024, 5, 20, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name        string
+		msg         Message
+		wantContent string // 序列化后 content 字段的JSON
+	}{
+		{
+			name:        "纯文本",
+			msg:         Message{Role: "user", Content: "你好"},
+			wantContent: `"你好"`,
+		},
+		{
+			name: "文本与图片片段",
+			msg: NewMultipartMessage("user", []ContentPart{
+				{Type: PartText, Text: "看图"},
+				{Type: PartImage, ImageURL: &ImageURL{URL: "attachment://a.png", Detail: "low"}},
+				{Type: PartText, Text: "说明"},
+			}),
+			wantContent: `[{"type":"text","text":"看图"},{"type":"image_url","image_url":{"url":"attachment://a.png","detail":"low"}},{"type":"text","text":"说明"}]`,
+		},
+		{
+			name: "工具调用与写入时间",
+			msg: Message{
+				Role:      "assistant",
+				ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "calc", Arguments: `{"x":1}`}}},
+				CreatedAt: created,
+			},
+			wantContent: `""`,
+		},
+		{
+			name:        "工具结果",
+			msg:         Message{Role: "tool", Content: "2", ToolCallID: "call_1"},
+			wantContent: `"2"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.msg)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			var raw struct {
+				Content json.RawMessage `json:"content"`
+			}
+			if err := json.Unmarshal(data, &raw); err != nil {
+				t.Fatalf("Unmarshal raw: %v", err)
+			}
+			if string(raw.Content) != tt.wantContent {
+				t.Errorf("content = %s, want %s", raw.Content, tt.wantContent)
+			}
+
+			var got Message
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("round trip = %+v, want %+v", got, tt.msg)
+			}
+		})
+	}
+}
+
+func TestMessageUnmarshalContent(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantText  string
+		wantParts int
+		wantErr   bool
+	}{
+		{name: "字符串", data: `{"role":"user","content":"hi"}`, wantText: "hi"},
+		{name: "null", data: `{"role":"assistant","content":null}`},
+		{name: "缺少content", data: `{"role":"assistant"}`},
+		{
+			name:      "片段拼接文本",
+			data:      `{"role":"user","content":[{"type":"text","text":"a"},{"type":"image_url","image_url":{"url":"data:x"}},{"type":"text","text":"b"}]}`,
+			wantText:  "a\nb",
+			wantParts: 3,
+		},
+		{name: "非法content", data: `{"role":"user","content":123}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Message
+			err := json.Unmarshal([]byte(tt.data), &m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if m.Content != tt.wantText || len(m.Parts) != tt.wantParts {
+				t.Errorf("got Content=%q Parts=%d, want %q %d", m.Content, len(m.Parts), tt.wantText, tt.wantParts)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestGenerationParamsMerge(t *testing.T) {
+	base := GenerationParams{Temperature: ptr(0.7), MaxTokens: ptr(512), Stop: []string{"END"}}
+	tests := []struct {
+		name     string
+		override GenerationParams
+		want     GenerationParams
+	}{
+		{name: "空覆盖", override: GenerationParams{}, want: base},
+		{
+			name:     "覆盖部分字段",
+			override: GenerationParams{Temperature: ptr(0.2), Seed: ptr(int64(42))},
+			want:     GenerationParams{Temperature: ptr(0.2), MaxTokens: ptr(512), Stop: []string{"END"}, Seed: ptr(int64(42))},
+		},
+		{
+			name:     "空stop切片也视为已设置",
+			override: GenerationParams{Stop: []string{}},
+			want:     GenerationParams{Temperature: ptr(0.7), MaxTokens: ptr(512), Stop: []string{}},
+		},
+		{
+			name: "覆盖全部字段",
+			override: GenerationParams{
+				Temperature: ptr(1.0), TopP: ptr(0.9), MaxTokens: ptr(8), Stop: []string{"a"},
+				FrequencyPenalty: ptr(0.5), PresencePenalty: ptr(-0.5), Seed: ptr(int64(1)),
+			},
+			want: GenerationParams{
+				Temperature: ptr(1.0), TopP: ptr(0.9), MaxTokens: ptr(8), Stop: []string{"a"},
+				FrequencyPenalty: ptr(0.5), PresencePenalty: ptr(-0.5), Seed: ptr(int64(1)),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := base.Merge(tt.override); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merge = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if *base.Temperature != 0.7 {
+		t.Errorf("Merge 修改了原参数: temperature=%v", *base.Temperature)
+	}
+}
+
+func TestGenerationParamsValidate(t *testing.T) {
+	long := string(make([]byte, maxStopLength+1))
+	tests := []struct {
+		name   string
+		params GenerationParams
+		want   int // 问题数
+	}{
+		{name: "全部为空", params: GenerationParams{}},
+		{
+			name: "边界值合法",
+			params: GenerationParams{
+				Temperature: ptr(2.0), TopP: ptr(1.0), MaxTokens: ptr(1024), Stop: []string{"a", "b", "c", "d"},
+				FrequencyPenalty: ptr(-2.0), PresencePenalty: ptr(2.0), Seed: ptr(int64(0)),
+			},
+		},
+		{name: "temperature 过大", params: GenerationParams{Temperature: ptr(2.1)}, want: 1},
+		{name: "top_p 为0", params: GenerationParams{TopP: ptr(0.0)}, want: 1},
+		{name: "max_tokens 超过上限", params: GenerationParams{MaxTokens: ptr(1025)}, want: 1},
+		{name: "max_tokens 为0", params: GenerationParams{MaxTokens: ptr(0)}, want: 1},
+		{name: "stop 过多", params: GenerationParams{Stop: []string{"a", "b", "c", "d", "e"}}, want: 1},
+		{name: "stop 含空串与超长项只报一次", params: GenerationParams{Stop: []string{"", long}}, want: 1},
+		{name: "seed 为负", params: GenerationParams{Seed: ptr(int64(-1))}, want: 1},
+		{
+			name:   "多个问题一并返回",
+			params: GenerationParams{Temperature: ptr(-1.0), FrequencyPenalty: ptr(3.0), PresencePenalty: ptr(-3.0)},
+			want:   3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.params.Validate(1024); len(got) != tt.want {
+				t.Errorf("Validate = %q, want %d 个问题", got, tt.want)
+			}
+		})
+	}
+}
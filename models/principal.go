@@ -0,0 +1,15 @@
+package models
+
+// Principal 发起请求的调用方身份
+type Principal struct {
+	ID    string `json:"id"`
+	Admin bool   `json:"admin"` // 管理员可访问所有会话
+}
+
+// Anonymous 未启用认证时使用的身份，不做任何访问限制
+var Anonymous = Principal{Admin: true}
+
+// CanAccess 判断调用方能否访问指定会话：管理员或会话所有者
+func (p Principal) CanAccess(meta SessionMeta) bool {
+	return p.Admin || meta.Owner == p.ID
+}
@@ -0,0 +1,14 @@
+package models
+
+// Role 角色（人设）定义，由 roles 目录下的文件加载
+type Role struct {
+	ID           string           `json:"id" yaml:"id"`
+	Name         string           `json:"name" yaml:"name"`                     // 展示名称
+	Order        int              `json:"order" yaml:"order"`                   // 下拉框中的排序，越小越靠前
+	SystemPrompt string           `json:"system_prompt" yaml:"system_prompt"`   // Markdown 文件中为正文
+	Provider     string           `json:"provider,omitempty" yaml:"provider"`   // 默认模型提供方，空为默认
+	Model        string           `json:"model,omitempty" yaml:"model"`         // 覆盖提供方的默认模型
+	Tools        []string         `json:"tools,omitempty" yaml:"tools"`         // 可调用的服务端工具
+	Knowledge    []string         `json:"knowledge,omitempty" yaml:"knowledge"` // 对话时检索的共享知识库
+	Params       GenerationParams `json:"params" yaml:",inline"`                // 默认生成参数
+}
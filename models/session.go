@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// SessionMeta 会话元信息
+type SessionMeta struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title"`
+	Role         string    `json:"role"`
+	Owner        string    `json:"owner,omitempty"` // 所属调用方，未启用认证时为空
+	MessageCount int       `json:"message_count"`   // 不含system消息
+	Usage        Usage     `json:"usage"`           // 累计token用量
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// SessionPatch 会话元信息的部分更新，nil字段表示不修改
+type SessionPatch struct {
+	Title        *string
+	Role         *string
+	SystemPrompt *string // 切换角色时同步替换system提示词
+}
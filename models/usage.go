@@ -0,0 +1,20 @@
+package models
+
+// Usage token用量，Cost 为按价格表折算的费用
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens" yaml:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens" yaml:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens" yaml:"total_tokens"`
+	Cost             float64 `json:"cost" yaml:"cost"`
+	// Estimated 为true表示上游未返回用量，由本地估算得出
+	Estimated bool `json:"estimated,omitempty" yaml:"estimated,omitempty"`
+}
+
+// Add 累加另一份用量
+func (u *Usage) Add(o Usage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.TotalTokens += o.TotalTokens
+	u.Cost += o.Cost
+	u.Estimated = u.Estimated || o.Estimated
+}
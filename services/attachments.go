@@ -0,0 +1,186 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"AiDemo/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// 会话内附件的引用前缀，写入历史时图片以引用保存，发送给模型前再展开为 data URL
+const attachmentScheme = "attachment://"
+
+// 允许上传的图片类型及对应的扩展名
+var imageTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// 附件文件名：内容摘要 + 扩展名，同一会话内相同图片只保存一份
+var attachmentNameRe = regexp.MustCompile(`^[0-9a-f]{32}\.(png|jpg|webp|gif)$`)
+
+// ErrAttachmentNotFound 附件不存在
+var ErrAttachmentNotFound = errors.New("附件不存在")
+
+// Image 校验通过的图片
+type Image struct {
+	MIME string
+	Data []byte
+}
+
+// MaxImageBytes 返回单张图片允许的最大字节数
+func MaxImageBytes() int {
+	return config.C.Attachments.MaxImageMB << 20
+}
+
+// ValidateImage 校验图片大小与类型，类型按文件内容识别而非扩展名
+func ValidateImage(data []byte) (Image, error) {
+	if len(data) == 0 {
+		return Image{}, errors.New("图片内容为空")
+	}
+	if len(data) > MaxImageBytes() {
+		return Image{}, fmt.Errorf("图片大小超过 %dMB", config.C.Attachments.MaxImageMB)
+	}
+	mime := http.DetectContentType(data)
+	if _, ok := imageTypes[mime]; !ok {
+		return Image{}, fmt.Errorf("不支持的图片类型: %s（仅支持 png、jpeg、webp、gif）", mime)
+	}
+	return Image{MIME: mime, Data: data}, nil
+}
+
+// DecodeImage 解码 data URL（data:image/png;base64,...）或纯 base64 字符串并校验
+func DecodeImage(s string) (Image, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "data:") {
+		meta, payload, ok := strings.Cut(s, ",")
+		if !ok || !strings.HasSuffix(meta, ";base64") {
+			return Image{}, errors.New("data URL 必须为 base64 编码")
+		}
+		s = payload
+	}
+	// 预先按编码长度拒绝过大的图片，避免解码占用内存
+	if base64.StdEncoding.DecodedLen(len(s)) > MaxImageBytes()+3 {
+		return Image{}, fmt.Errorf("图片大小超过 %dMB", config.C.Attachments.MaxImageMB)
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return Image{}, errors.New("图片不是合法的 base64 编码")
+	}
+	return ValidateImage(data)
+}
+
+// SaveAttachment 将图片保存到会话的附件目录，返回可写入消息的图片片段
+func SaveAttachment(ctx context.Context, sessionID string, img Image) (models.ContentPart, error) {
+	sum := sha256.Sum256(img.Data)
+	name := hex.EncodeToString(sum[:16]) + imageTypes[img.MIME]
+	dir := attachmentDir(sessionID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return models.ContentPart{}, fmt.Errorf("创建附件目录失败: %w", err)
+	}
+
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		tmp, err := os.CreateTemp(dir, ".upload-*")
+		if err != nil {
+			return models.ContentPart{}, fmt.Errorf("保存附件失败: %w", err)
+		}
+		_, werr := tmp.Write(img.Data)
+		cerr := tmp.Close()
+		if werr != nil || cerr != nil {
+			os.Remove(tmp.Name())
+			return models.ContentPart{}, fmt.Errorf("保存附件失败: %v", errors.Join(werr, cerr))
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			os.Remove(tmp.Name())
+			return models.ContentPart{}, fmt.Errorf("保存附件失败: %w", err)
+		}
+		utils.FromContext(ctx).Info("已保存附件(session=%s, name=%s, %d 字节)", sessionID, name, len(img.Data))
+	}
+	return models.ContentPart{Type: models.PartImage, ImageURL: &models.ImageURL{URL: attachmentScheme + name}}, nil
+}
+
+// AttachmentPath 返回会话附件的本地路径，名称不合法或文件不存在时返回 ErrAttachmentNotFound
+func AttachmentPath(sessionID, name string) (string, error) {
+	if !attachmentNameRe.MatchString(name) {
+		return "", ErrAttachmentNotFound
+	}
+	path := filepath.Join(attachmentDir(sessionID), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrAttachmentNotFound
+	}
+	return path, nil
+}
+
+// RemoveAttachments 删除会话的全部附件
+func RemoveAttachments(ctx context.Context, sessionID string) {
+	if err := os.RemoveAll(attachmentDir(sessionID)); err != nil {
+		utils.FromContext(ctx).Warning("删除会话附件失败(session=%s): %v", sessionID, err)
+	}
+}
+
+// IsAttachmentRef 判断图片地址是否为会话附件引用
+func IsAttachmentRef(url string) bool {
+	return strings.HasPrefix(url, attachmentScheme)
+}
+
+// resolveAttachments 把历史中的附件引用展开为 data URL，返回的消息为拷贝，
+// 附件已丢失时以文本说明代替
+func resolveAttachments(ctx context.Context, sessionID string, h []models.Message) []models.Message {
+	var out []models.Message
+	for i, m := range h {
+		if !m.HasImages() {
+			continue
+		}
+		if out == nil {
+			out = copyHistory(h)
+		}
+		parts := make([]models.ContentPart, len(m.Parts))
+		for j, p := range m.Parts {
+			parts[j] = p
+			if p.Type != models.PartImage || p.ImageURL == nil || !IsAttachmentRef(p.ImageURL.URL) {
+				continue
+			}
+			url, err := attachmentDataURL(sessionID, strings.TrimPrefix(p.ImageURL.URL, attachmentScheme))
+			if err != nil {
+				utils.FromContext(ctx).Warning("读取附件失败(session=%s): %v", sessionID, err)
+				parts[j] = models.ContentPart{Type: models.PartText, Text: "[图片已丢失]"}
+				continue
+			}
+			parts[j].ImageURL = &models.ImageURL{URL: url, Detail: p.ImageURL.Detail}
+		}
+		out[i].Parts = parts
+	}
+	if out == nil {
+		return h
+	}
+	return out
+}
+
+func attachmentDataURL(sessionID, name string) (string, error) {
+	path, err := AttachmentPath(sessionID, name)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// attachmentDir 会话的附件目录，会话ID经URL安全的base64编码，避免路径穿越
+func attachmentDir(sessionID string) string {
+	return filepath.Join(config.C.Attachments.Dir, base64.RawURLEncoding.EncodeToString([]byte(sessionID)))
+}
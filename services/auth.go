@@ -0,0 +1,104 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// 签名令牌的前缀，用于与静态API Key区分
+const tokenPrefix = "at."
+
+var (
+	// ErrUnauthenticated 凭证缺失、无效或已过期
+	ErrUnauthenticated = errors.New("凭证无效或已过期")
+	// ErrTokensDisabled 未配置令牌密钥，无法签发令牌
+	ErrTokensDisabled = errors.New("未配置 auth.token_secret，无法签发令牌")
+)
+
+// tokenClaims 签名令牌的载荷
+type tokenClaims struct {
+	Subject string `json:"sub"`
+	Admin   bool   `json:"adm,omitempty"`
+	Expires int64  `json:"exp"`
+}
+
+// Authenticate 校验请求携带的凭证（静态API Key或签名令牌），返回对应的调用方
+func Authenticate(credential string) (models.Principal, error) {
+	if credential == "" {
+		return models.Principal{}, ErrUnauthenticated
+	}
+	if strings.HasPrefix(credential, tokenPrefix) {
+		return verifyToken(credential)
+	}
+
+	// 逐个比较全部key，避免通过响应时间推断key内容
+	var matched *config.APIKeyConfig
+	for i, k := range config.C.Auth.Keys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(credential)) == 1 {
+			matched = &config.C.Auth.Keys[i]
+		}
+	}
+	if matched == nil {
+		return models.Principal{}, ErrUnauthenticated
+	}
+	return models.Principal{ID: matched.Principal, Admin: matched.Admin}, nil
+}
+
+// IssueToken 为调用方签发签名令牌，ttl不超过 config.C.Auth.TokenTTL
+func IssueToken(p models.Principal, ttl time.Duration) (string, time.Time, error) {
+	secret := config.C.Auth.TokenSecret
+	if secret == "" {
+		return "", time.Time{}, ErrTokensDisabled
+	}
+	maxTTL := time.Duration(config.C.Auth.TokenTTL) * time.Second
+	if ttl <= 0 || ttl > maxTTL {
+		ttl = maxTTL
+	}
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+
+	payload, err := json.Marshal(tokenClaims{Subject: p.ID, Admin: p.Admin, Expires: expires.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return tokenPrefix + body + "." + signToken(secret, body), expires, nil
+}
+
+// verifyToken 校验令牌签名与有效期
+func verifyToken(token string) (models.Principal, error) {
+	secret := config.C.Auth.TokenSecret
+	if secret == "" {
+		return models.Principal{}, ErrUnauthenticated
+	}
+	body, sig, ok := strings.Cut(strings.TrimPrefix(token, tokenPrefix), ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signToken(secret, body))) {
+		return models.Principal{}, ErrUnauthenticated
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return models.Principal{}, ErrUnauthenticated
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return models.Principal{}, ErrUnauthenticated
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return models.Principal{}, ErrUnauthenticated
+	}
+	return models.Principal{ID: claims.Subject, Admin: claims.Admin}, nil
+}
+
+func signToken(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,440 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"AiDemo/utils"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 重试退避参数
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 20 * time.Second
+)
+
+// chatClient 封装 OpenAI 兼容的 chat/completions 协议，
+// 豆包（方舟）与其他 OpenAI 兼容服务共用这一实现
+type chatClient struct {
+	url      string
+	embedURL string // embeddings 接口地址，与 chat/completions 同级
+	apiKey   string
+	model    string
+	window   int // 上下文窗口大小
+
+	http       *http.Client // 普通请求，整体超时
+	streamHTTP *http.Client // 流式请求，仅限制等待响应头的时间
+	maxRetries int
+	dispatcher *dispatcher // 限制同时进行的上游请求数
+}
+
+func newChatClient(name, url, apiKey, model string, window int) *chatClient {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: config.C.UpstreamTimeout(),
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+	}
+	return &chatClient{
+		url:        url,
+		embedURL:   strings.TrimSuffix(strings.TrimRight(url, "/"), "/chat/completions") + "/embeddings",
+		apiKey:     apiKey,
+		model:      model,
+		window:     window,
+		http:       &http.Client{Transport: transport, Timeout: config.C.UpstreamTimeout()},
+		streamHTTP: &http.Client{Transport: transport},
+		maxRetries: config.C.Upstream.MaxRetries,
+		dispatcher: newDispatcher(name),
+	}
+}
+
+// modelInfo 返回提供方名称为name的模型信息
+func (cc *chatClient) modelInfo(name string) ModelInfo {
+	return ModelInfo{Provider: name, ID: cc.model, BaseURL: cc.url, ContextWindow: cc.window}
+}
+
+func (cc *chatClient) chat(ctx context.Context, messages []models.Message, opts ChatOptions) (ChatResult, error) {
+	log := utils.FromContext(ctx)
+	log.Debug("准备调用API: %s", cc.url)
+
+	body := cc.requestBody(messages, opts, false)
+	result := ChatResult{Model: body.Model}
+
+	log.Info("发送API请求...")
+	resp, err := cc.send(ctx, cc.http, cc.url, body, false)
+	if err != nil {
+		return result, err
+	}
+	defer closeBody(ctx, resp.Body)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Error("读取响应体失败: %v", err)
+		return result, contextErr(ctx, err)
+	}
+
+	log.Debug("API原始响应: %s", string(respBody))
+
+	var response models.ResponseBody
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		log.Error("解析响应JSON失败: %v", err)
+		return result, err
+	}
+
+	if len(response.Choices) > 0 {
+		result.Content = response.Choices[0].Message.Content
+		result.FinishReason = response.Choices[0].FinishReason
+		result.ToolCalls = response.Choices[0].Message.ToolCalls
+		result.Usage = resolveUsage(response.Usage, messages, result.Content)
+		log.Info("API调用成功，返回内容长度: %d，用量: %d tokens", len(result.Content), result.Usage.TotalTokens)
+		return result, nil
+	}
+
+	log.Error("API返回空结果")
+	return result, fmt.Errorf("API返回空结果")
+}
+
+func (cc *chatClient) chatStream(ctx context.Context, messages []models.Message, opts ChatOptions, onDelta func(delta string) error) (ChatResult, error) {
+	log := utils.FromContext(ctx)
+	log.Debug("准备调用流式API: %s", cc.url)
+
+	body := cc.requestBody(messages, opts, true)
+	result := ChatResult{Model: body.Model}
+
+	log.Info("发送流式API请求...")
+	resp, err := cc.send(ctx, cc.streamHTTP, cc.url, body, true)
+	if err != nil {
+		return result, err
+	}
+	defer closeBody(ctx, resp.Body)
+
+	var full strings.Builder
+	var usage *models.Usage
+	var calls []models.ToolCall
+	// 结束时（含中途出错）按已收到的内容补全结果
+	finish := func() ChatResult {
+		result.Content = full.String()
+		result.ToolCalls = calls
+		result.Usage = resolveUsage(usage, messages, result.Content)
+		return result
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	// 单个data行可能较长，放宽缓冲区上限
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue // 忽略空行、注释及其他字段
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk models.StreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Warning("解析流式片段失败: %v, 原始数据: %s", err, data)
+			continue
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage // 用量在最后一个片段中返回
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		if reason := chunk.Choices[0].FinishReason; reason != "" {
+			result.FinishReason = reason
+		}
+		calls = mergeToolCallDeltas(calls, chunk.Choices[0].Delta.ToolCalls)
+		if chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		full.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			log.Warning("流式输出被中止: %v", err)
+			return finish(), err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Error("读取流式响应失败: %v", err)
+		return finish(), contextErr(ctx, err)
+	}
+
+	if full.Len() == 0 && len(calls) == 0 {
+		log.Error("流式API返回空结果")
+		return result, fmt.Errorf("API返回空结果")
+	}
+
+	result = finish()
+	log.Info("流式API调用成功，返回内容长度: %d，用量: %d tokens", full.Len(), result.Usage.TotalTokens)
+	return result, nil
+}
+
+// embed 调用 embeddings 接口，返回与inputs一一对应的向量
+func (cc *chatClient) embed(ctx context.Context, model string, inputs []string) (EmbedResult, error) {
+	log := utils.FromContext(ctx)
+	result := EmbedResult{Model: model}
+	body := models.EmbeddingRequest{Model: model, Input: inputs, EncodingFormat: "float"}
+
+	log.Debug("发送向量化请求: %s (%d 条)", cc.embedURL, len(inputs))
+	resp, err := cc.send(ctx, cc.http, cc.embedURL, body, false)
+	if err != nil {
+		return result, err
+	}
+	defer closeBody(ctx, resp.Body)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Error("读取响应体失败: %v", err)
+		return result, contextErr(ctx, err)
+	}
+	var response models.EmbeddingResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		log.Error("解析向量化响应失败: %v", err)
+		return result, err
+	}
+	if len(response.Data) != len(inputs) {
+		return result, fmt.Errorf("向量化接口返回 %d 条结果，期望 %d 条", len(response.Data), len(inputs))
+	}
+
+	result.Vectors = make([][]float32, len(inputs))
+	for _, d := range response.Data {
+		if d.Index < 0 || d.Index >= len(inputs) || len(d.Embedding) == 0 {
+			return result, fmt.Errorf("向量化接口返回了无效的结果(index=%d)", d.Index)
+		}
+		result.Vectors[d.Index] = d.Embedding
+	}
+	if response.Model != "" {
+		result.Model = response.Model
+	}
+	if response.Usage != nil && response.Usage.TotalTokens > 0 {
+		result.Usage = *response.Usage
+		result.Usage.Cost = 0
+	} else {
+		for _, in := range inputs {
+			result.Usage.PromptTokens += EstimateTokens(in)
+		}
+		result.Usage.TotalTokens = result.Usage.PromptTokens
+		result.Usage.Estimated = true
+	}
+	return result, nil
+}
+
+// mergeToolCallDeltas 按 index 合并流式工具调用片段：首个片段带ID与名称，后续片段追加参数
+func mergeToolCallDeltas(calls []models.ToolCall, deltas []models.StreamToolCall) []models.ToolCall {
+	for _, d := range deltas {
+		for len(calls) <= d.Index {
+			calls = append(calls, models.ToolCall{Type: "function"})
+		}
+		call := &calls[d.Index]
+		if d.ID != "" {
+			call.ID = d.ID
+		}
+		if d.Type != "" {
+			call.Type = d.Type
+		}
+		call.Function.Name += d.Function.Name
+		call.Function.Arguments += d.Function.Arguments
+	}
+	return calls
+}
+
+// resolveUsage 优先使用上游返回的用量，缺失时按本地估算补齐
+func resolveUsage(usage *models.Usage, messages []models.Message, content string) models.Usage {
+	if usage != nil && usage.TotalTokens > 0 {
+		u := *usage
+		u.Cost = 0
+		u.Estimated = false
+		return u
+	}
+	prompt := EstimateMessagesTokens(messages)
+	completion := EstimateTokens(content)
+	return models.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: completion,
+		TotalTokens:      prompt + completion,
+		Estimated:        true,
+	}
+}
+
+// requestBody 按调用选项构造请求体，未指定模型时使用提供方默认模型
+func (cc *chatClient) requestBody(messages []models.Message, opts ChatOptions, stream bool) models.RequestBody {
+	model := opts.Model
+	if model == "" {
+		model = cc.model
+	}
+	// 消息时间仅用于会话记录，不发送给上游
+	msgs := make([]models.Message, len(messages))
+	for i, m := range messages {
+		m.CreatedAt = time.Time{}
+		msgs[i] = m
+	}
+	body := models.RequestBody{
+		Model:            model,
+		Messages:         msgs,
+		Stream:           stream,
+		Tools:            opts.Tools,
+		GenerationParams: opts.Params,
+	}
+	if stream {
+		body.StreamOptions = &models.StreamOptions{IncludeUsage: true}
+	}
+	return body
+}
+
+// send 发送请求并返回状态码为200的响应。对限流、上游不可用及网络错误
+// 按指数退避加随机抖动重试，优先遵循上游的 Retry-After；其余错误直接返回分类后的错误。
+// ctx取消或超时时立即返回ctx的错误，不再重试；请求已发出后发生的客户端超时也不重试，
+// 此时上游可能仍在生成，重试会重复计费并叠加等待时间。
+// 每次尝试前向调度器申请名额，成功时名额在响应体关闭后归还，排队失败不再重试
+func (cc *chatClient) send(ctx context.Context, client *http.Client, url string, body interface{}, stream bool) (*http.Response, error) {
+	log := utils.FromContext(ctx)
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		log.Error("请求体序列化失败: %v", err)
+		return nil, err
+	}
+
+	log.Debug("API请求体: %s", string(jsonData))
+
+	for attempt := 0; ; attempt++ {
+		req, err := cc.newRequest(ctx, url, jsonData, stream)
+		if err != nil {
+			return nil, err
+		}
+
+		var sent atomic.Bool
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			WroteRequest: func(httptrace.WroteRequestInfo) { sent.Store(true) },
+		}))
+
+		release, err := cc.dispatcher.acquire(ctx)
+		if err != nil {
+			return nil, contextErr(ctx, err)
+		}
+
+		var ue *UpstreamError
+		resp, err := client.Do(req)
+		if err != nil {
+			release()
+			if ctx.Err() != nil {
+				log.Warning("请求已取消: %v", ctx.Err())
+				return nil, contextErr(ctx, err)
+			}
+			log.Error("HTTP请求失败: %v", err)
+			ue = &UpstreamError{Kind: ErrKindUpstreamUnavailable, Err: err}
+			if sent.Load() && isTimeout(err) {
+				log.Warning("请求发出后等待上游响应超时，不再重试")
+				return nil, ue
+			}
+		} else {
+			log.Info("API响应状态码: %d", resp.StatusCode)
+			if resp.StatusCode == http.StatusOK {
+				resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+				return resp, nil
+			}
+			respBody, _ := io.ReadAll(resp.Body)
+			closeBody(ctx, resp.Body)
+			release()
+			ue = parseUpstreamError(resp, respBody)
+			log.Error("API返回错误: %v", ue)
+		}
+
+		if !ue.Retryable() || attempt >= cc.maxRetries {
+			return nil, ue
+		}
+
+		delay := backoffDelay(attempt, ue.RetryAfter)
+		log.Warning("第 %d 次重试将在 %v 后进行", attempt+1, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, contextErr(ctx, ue)
+		case <-timer.C:
+		}
+	}
+}
+
+// isTimeout 判断是否为客户端超时（整体超时或等待响应头超时）
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// contextErr ctx已结束时返回包装了ctx错误的err，便于调用方用 errors.Is 判断取消与超时
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %v", ctxErr, err)
+	}
+	return err
+}
+
+// backoffDelay 计算第attempt次失败后的等待时间：
+// 上游给出 Retry-After 时以其为准，否则为带完全抖动的指数退避
+func backoffDelay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if retryAfter > retryMaxDelay {
+			return retryMaxDelay
+		}
+		return retryAfter
+	}
+	backoff := retryBaseDelay << attempt
+	if backoff <= 0 || backoff > retryMaxDelay {
+		backoff = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(backoff)) + 1)
+}
+
+// newRequest 构造带鉴权头的HTTP请求
+func (cc *chatClient) newRequest(ctx context.Context, url string, jsonData []byte, stream bool) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+	if err != nil {
+		utils.FromContext(ctx).Error("创建HTTP请求失败: %v", err)
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	if cc.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cc.apiKey)
+	}
+	return req, nil
+}
+
+// releaseBody 关闭时归还调度名额的响应体
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+func closeBody(ctx context.Context, body io.ReadCloser) {
+	if err := body.Close(); err != nil {
+		utils.FromContext(ctx).Warning("关闭响应体失败: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter time.Duration
+		min, max   time.Duration // 结果所在的闭区间
+	}{
+		{name: "遵循Retry-After", attempt: 0, retryAfter: 2 * time.Second, min: 2 * time.Second, max: 2 * time.Second},
+		{name: "Retry-After 超过上限", attempt: 0, retryAfter: time.Hour, min: retryMaxDelay, max: retryMaxDelay},
+		{name: "首次重试", attempt: 0, min: 1, max: retryBaseDelay},
+		{name: "指数增长", attempt: 3, min: 1, max: retryBaseDelay << 3},
+		{name: "超过上限", attempt: 10, min: 1, max: retryMaxDelay},
+		{name: "移位溢出", attempt: 80, min: 1, max: retryMaxDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 随机抖动，多次取样
+			for i := 0; i < 100; i++ {
+				if got := backoffDelay(tt.attempt, tt.retryAfter); got < tt.min || got > tt.max {
+					t.Fatalf("backoffDelay(%d, %v) = %v, want [%v, %v]", tt.attempt, tt.retryAfter, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
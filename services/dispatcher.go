@@ -0,0 +1,273 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/utils"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Priority 上游请求的调度优先级，数值越大越先放行
+type Priority int
+
+const (
+	PriorityBatch       Priority = iota // 后台任务：摘要压缩、文档向量化等
+	PriorityInteractive                 // 普通用户的对话，默认优先级
+	PriorityAdmin                       // 管理员的请求
+	priorityLevels
+)
+
+// String 返回优先级名称
+func (p Priority) String() string {
+	switch p {
+	case PriorityBatch:
+		return "batch"
+	case PriorityAdmin:
+		return "admin"
+	default:
+		return "interactive"
+	}
+}
+
+// errUpstreamBusy 排队超时或队列已满，作为 UpstreamError 的底层错误返回
+var errUpstreamBusy = errors.New("上游请求排队已满或等待超时")
+
+// queueRetryAfter 排队失败时建议客户端的重试等待时间
+const queueRetryAfter = 5 * time.Second
+
+type priorityKey struct{}
+
+// WithPriority 返回携带调度优先级的ctx，经该ctx发起的上游请求按此优先级排队
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom 返回ctx携带的调度优先级，未设置时为 PriorityInteractive
+func PriorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= 0 && p < priorityLevels {
+		return p
+	}
+	return PriorityInteractive
+}
+
+// ClassStats 单个优先级的调度统计
+type ClassStats struct {
+	Queued     int     `json:"queued"`      // 当前排队数
+	Dispatched int64   `json:"dispatched"`  // 累计放行数
+	Rejected   int64   `json:"rejected"`    // 累计因队列已满或等待超时被拒绝的数量
+	Cancelled  int64   `json:"cancelled"`   // 累计在排队期间被取消的数量
+	AvgWaitMs  float64 `json:"avg_wait_ms"` // 放行请求的平均等待时间
+	MaxWaitMs  int64   `json:"max_wait_ms"` // 放行请求的最长等待时间
+}
+
+// DispatcherStats 单个提供方的调度统计
+type DispatcherStats struct {
+	Provider string                `json:"provider"`
+	Limit    int                   `json:"limit"` // 并发上限，0表示不限制
+	InFlight int                   `json:"in_flight"`
+	Queued   int                   `json:"queued"`
+	Classes  map[string]ClassStats `json:"classes"` // 按优先级名称
+}
+
+// waiter 排队中的请求，获得名额时关闭 ready
+type waiter struct {
+	ready    chan struct{}
+	priority Priority
+}
+
+// classCounters 单个优先级的累计计数
+type classCounters struct {
+	dispatched, rejected, cancelled int64
+	totalWait, maxWait              time.Duration
+}
+
+// dispatcher 限制单个提供方同时进行的上游请求数。名额用尽时请求按优先级排队，
+// 同一优先级先到先得；释放名额时直接移交给优先级最高的排队者
+type dispatcher struct {
+	name     string
+	limit    int
+	maxWait  time.Duration
+	maxQueue int
+
+	mu       sync.Mutex
+	inFlight int
+	queues   [priorityLevels][]*waiter
+	counters [priorityLevels]classCounters
+}
+
+// 已创建的调度器，供统计接口查询
+var (
+	dispatchers   = make(map[string]*dispatcher)
+	dispatchersMu sync.RWMutex
+)
+
+// newDispatcher 按 config.C.Upstream 创建提供方name的调度器，同名调度器被替换
+func newDispatcher(name string) *dispatcher {
+	u := config.C.Upstream
+	d := &dispatcher{
+		name:     name,
+		limit:    u.MaxConcurrent,
+		maxWait:  config.C.QueueWait(),
+		maxQueue: u.MaxQueue,
+	}
+	dispatchersMu.Lock()
+	dispatchers[name] = d
+	dispatchersMu.Unlock()
+	return d
+}
+
+// acquire 获取一个上游请求名额，优先级取自ctx。名额用尽时排队等待，
+// 队列已满或等待超时返回可重试的 UpstreamError，ctx结束时返回ctx的错误。
+// 成功时调用方须在请求结束后调用 release 归还名额
+func (d *dispatcher) acquire(ctx context.Context) (release func(), err error) {
+	log := utils.FromContext(ctx)
+	p := PriorityFrom(ctx)
+
+	d.mu.Lock()
+	if d.limit <= 0 || (d.inFlight < d.limit && d.queued() == 0) {
+		d.inFlight++
+		d.counters[p].dispatched++
+		d.mu.Unlock()
+		return d.releaseOnce(), nil
+	}
+	if d.maxQueue > 0 && d.queued() >= d.maxQueue {
+		d.counters[p].rejected++
+		d.mu.Unlock()
+		log.Warning("上游请求队列已满(provider=%s, priority=%s, queued=%d)", d.name, p, d.maxQueue)
+		return nil, &UpstreamError{Kind: ErrKindUpstreamUnavailable, Err: errUpstreamBusy, RetryAfter: queueRetryAfter}
+	}
+	w := &waiter{ready: make(chan struct{}), priority: p}
+	d.queues[p] = append(d.queues[p], w)
+	d.mu.Unlock()
+
+	log.Debug("上游并发已满，请求排队中(provider=%s, priority=%s)", d.name, p)
+	start := time.Now()
+	timer := time.NewTimer(d.maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+		d.recordWait(p, time.Since(start))
+		return d.releaseOnce(), nil
+	case <-timer.C:
+		err = &UpstreamError{Kind: ErrKindUpstreamUnavailable, Err: errUpstreamBusy, RetryAfter: queueRetryAfter}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	d.mu.Lock()
+	granted := !d.dequeue(w)
+	if !granted {
+		if ctx.Err() != nil {
+			d.counters[p].cancelled++
+		} else {
+			d.counters[p].rejected++
+			log.Warning("上游请求排队超时(provider=%s, priority=%s, wait=%v)", d.name, p, d.maxWait)
+		}
+	}
+	d.mu.Unlock()
+
+	if granted {
+		// 超时的同时已获得名额：未取消则照常放行，否则归还给下一个排队者
+		if ctx.Err() == nil {
+			d.recordWait(p, time.Since(start))
+			return d.releaseOnce(), nil
+		}
+		d.release()
+	}
+	return nil, err
+}
+
+// releaseOnce 返回只生效一次的名额归还函数
+func (d *dispatcher) releaseOnce() func() {
+	var once sync.Once
+	return func() { once.Do(d.release) }
+}
+
+// release 归还名额：有排队者时直接移交给优先级最高者，否则减少在途数
+func (d *dispatcher) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for p := priorityLevels - 1; p >= 0; p-- {
+		if q := d.queues[p]; len(q) > 0 {
+			d.queues[p] = q[1:]
+			d.counters[p].dispatched++
+			close(q[0].ready)
+			return
+		}
+	}
+	d.inFlight--
+}
+
+// dequeue 将w移出队列，w已获得名额（不在队列中）时返回false。调用方须持有 d.mu
+func (d *dispatcher) dequeue(w *waiter) bool {
+	q := d.queues[w.priority]
+	for i, x := range q {
+		if x == w {
+			d.queues[w.priority] = append(q[:i:i], q[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dispatcher) recordWait(p Priority, wait time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := &d.counters[p]
+	c.totalWait += wait
+	if wait > c.maxWait {
+		c.maxWait = wait
+	}
+}
+
+// queued 返回排队总数。调用方须持有 d.mu
+func (d *dispatcher) queued() int {
+	n := 0
+	for _, q := range d.queues {
+		n += len(q)
+	}
+	return n
+}
+
+func (d *dispatcher) stats() DispatcherStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := DispatcherStats{
+		Provider: d.name,
+		Limit:    d.limit,
+		InFlight: d.inFlight,
+		Queued:   d.queued(),
+		Classes:  make(map[string]ClassStats, priorityLevels),
+	}
+	for p := Priority(0); p < priorityLevels; p++ {
+		c := d.counters[p]
+		cs := ClassStats{
+			Queued:     len(d.queues[p]),
+			Dispatched: c.dispatched,
+			Rejected:   c.rejected,
+			Cancelled:  c.cancelled,
+			MaxWaitMs:  c.maxWait.Milliseconds(),
+		}
+		if c.dispatched > 0 {
+			cs.AvgWaitMs = float64(c.totalWait.Microseconds()) / 1000 / float64(c.dispatched)
+		}
+		s.Classes[p.String()] = cs
+	}
+	return s
+}
+
+// UpstreamStats 返回各提供方的上游调度统计（按名称排序）
+func UpstreamStats() []DispatcherStats {
+	dispatchersMu.RLock()
+	defer dispatchersMu.RUnlock()
+	stats := make([]DispatcherStats, 0, len(dispatchers))
+	for _, d := range dispatchers {
+		stats = append(stats, d.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Provider < stats[j].Provider })
+	return stats
+}
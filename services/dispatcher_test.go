@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitQueued 等待调度器中排队的请求数达到n
+func waitQueued(t *testing.T, d *dispatcher, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for d.stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("排队数 = %d, want %d", d.stats().Queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDispatcherUnlimited(t *testing.T) {
+	d := &dispatcher{name: "test"}
+	var releases []func()
+	for i := 0; i < 10; i++ {
+		release, err := d.acquire(context.Background())
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		releases = append(releases, release)
+	}
+	if s := d.stats(); s.InFlight != 10 || s.Queued != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+	for _, release := range releases {
+		release()
+		release() // 重复调用无效
+	}
+	if s := d.stats(); s.InFlight != 0 {
+		t.Errorf("InFlight = %d, want 0", s.InFlight)
+	}
+}
+
+func TestDispatcherPriorityOrder(t *testing.T) {
+	d := &dispatcher{name: "test", limit: 1, maxWait: 5 * time.Second}
+	release, err := d.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	// 依次以低、中、高、中优先级排队，放行顺序应为高、中、中、低，同级先到先得
+	order := []Priority{PriorityBatch, PriorityInteractive, PriorityAdmin, PriorityInteractive}
+	granted := make(chan int, len(order))
+	for i, p := range order {
+		go func(i int, p Priority) {
+			r, err := d.acquire(WithPriority(context.Background(), p))
+			if err != nil {
+				t.Errorf("acquire(%s): %v", p, err)
+				return
+			}
+			granted <- i
+			r()
+		}(i, p)
+		waitQueued(t, d, i+1)
+	}
+
+	release()
+	var got []int
+	for range order {
+		select {
+		case i := <-granted:
+			got = append(got, i)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("等待放行超时，已放行 %v", got)
+		}
+	}
+	want := []int{2, 1, 3, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("放行顺序 = %v, want %v", got, want)
+		}
+	}
+
+	s := d.stats()
+	if s.InFlight != 0 || s.Queued != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+	if c := s.Classes["interactive"]; c.Dispatched != 3 {
+		t.Errorf("interactive dispatched = %d, want 3", c.Dispatched)
+	}
+}
+
+func TestDispatcherRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxQueue  int
+		queued    int // 预先排队的请求数
+		cancel    bool
+		wantErr   error
+		wantStats func(ClassStats) bool
+	}{
+		{
+			name: "队列已满", maxQueue: 1, queued: 1, wantErr: errUpstreamBusy,
+			wantStats: func(c ClassStats) bool { return c.Rejected == 1 },
+		},
+		{
+			name: "排队超时", wantErr: errUpstreamBusy,
+			wantStats: func(c ClassStats) bool { return c.Rejected == 1 },
+		},
+		{
+			name: "排队期间取消", cancel: true, wantErr: context.Canceled,
+			wantStats: func(c ClassStats) bool { return c.Cancelled == 1 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dispatcher{name: "test", limit: 1, maxWait: 50 * time.Millisecond, maxQueue: tt.maxQueue}
+			release, err := d.acquire(context.Background())
+			if err != nil {
+				t.Fatalf("acquire: %v", err)
+			}
+			defer release()
+			for i := 0; i < tt.queued; i++ {
+				d.mu.Lock()
+				d.queues[PriorityBatch] = append(d.queues[PriorityBatch], &waiter{ready: make(chan struct{}), priority: PriorityBatch})
+				d.mu.Unlock()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				go func() {
+					for d.stats().Queued == 0 {
+						time.Sleep(time.Millisecond)
+					}
+					cancel()
+				}()
+			}
+			_, err = d.acquire(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("acquire err = %v, want %v", err, tt.wantErr)
+			}
+			if ue, ok := AsUpstreamError(err); ok && (!ue.Retryable() || ue.RetryAfter != queueRetryAfter) {
+				t.Errorf("UpstreamError = %+v", ue)
+			}
+			s := d.stats()
+			if s.Queued != tt.queued {
+				t.Errorf("Queued = %d, want %d", s.Queued, tt.queued)
+			}
+			if c := s.Classes["interactive"]; !tt.wantStats(c) {
+				t.Errorf("interactive stats = %+v", c)
+			}
+		})
+	}
+}
+
+func TestPriorityFrom(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want Priority
+	}{
+		{name: "未设置", ctx: context.Background(), want: PriorityInteractive},
+		{name: "管理员", ctx: WithPriority(context.Background(), PriorityAdmin), want: PriorityAdmin},
+		{name: "后台任务", ctx: WithPriority(context.Background(), PriorityBatch), want: PriorityBatch},
+		{name: "越界值", ctx: WithPriority(context.Background(), Priority(99)), want: PriorityInteractive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PriorityFrom(tt.ctx); got != tt.want {
+				t.Errorf("PriorityFrom = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind 上游调用错误分类
+type ErrorKind string
+
+const (
+	ErrKindAuth                ErrorKind = "auth"                 // 鉴权失败（API Key 无效或无权限）
+	ErrKindRateLimited         ErrorKind = "rate_limited"         // 触发限流
+	ErrKindQuota               ErrorKind = "quota_exceeded"       // 额度耗尽或账户欠费
+	ErrKindContextTooLong      ErrorKind = "context_too_long"     // 输入超出模型上下文长度
+	ErrKindUpstreamUnavailable ErrorKind = "upstream_unavailable" // 上游服务不可用、超时或过载
+	ErrKindBadRequest          ErrorKind = "bad_request"          // 请求参数错误
+	ErrKindUnknown             ErrorKind = "unknown"              // 其他未归类错误
+)
+
+// UpstreamError 上游模型服务返回的错误
+type UpstreamError struct {
+	Kind       ErrorKind
+	StatusCode int           // HTTP状态码，网络错误时为0
+	Code       string        // 上游返回的错误码
+	Message    string        // 上游返回的错误信息
+	RetryAfter time.Duration // 上游建议的重试等待时间
+	Err        error         // 底层错误（如网络错误）
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("上游服务调用失败(%s): %v", e.Kind, e.Err)
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("上游服务返回错误(%s, HTTP %d, %s): %s", e.Kind, e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("上游服务返回错误(%s, HTTP %d): %s", e.Kind, e.StatusCode, e.Message)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable 是否值得重试
+func (e *UpstreamError) Retryable() bool {
+	return e.Kind == ErrKindRateLimited || e.Kind == ErrKindUpstreamUnavailable
+}
+
+// AsUpstreamError 从错误链中提取 UpstreamError
+func AsUpstreamError(err error) (*UpstreamError, bool) {
+	var ue *UpstreamError
+	if errors.As(err, &ue) {
+		return ue, true
+	}
+	return nil, false
+}
+
+// apiErrorBody 方舟及 OpenAI 兼容接口的错误响应体
+type apiErrorBody struct {
+	Error struct {
+		Code    json.RawMessage `json:"code"`
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+	} `json:"error"`
+}
+
+// parseUpstreamError 根据HTTP状态码与错误响应体构造分类后的错误
+func parseUpstreamError(resp *http.Response, body []byte) *UpstreamError {
+	ue := &UpstreamError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+
+	var eb apiErrorBody
+	if err := json.Unmarshal(body, &eb); err == nil && (eb.Error.Message != "" || len(eb.Error.Code) > 0) {
+		ue.Code = strings.Trim(string(eb.Error.Code), `"`)
+		if ue.Code == "" || ue.Code == "null" {
+			ue.Code = eb.Error.Type
+		}
+		ue.Message = eb.Error.Message
+	} else {
+		ue.Message = strings.TrimSpace(string(body))
+		if ue.Message == "" {
+			ue.Message = http.StatusText(resp.StatusCode)
+		}
+	}
+
+	ue.Kind = classifyError(resp.StatusCode, ue.Code, ue.Message)
+	return ue
+}
+
+// classifyError 结合错误码、错误信息与状态码判断错误类别
+func classifyError(status int, code, message string) ErrorKind {
+	c := strings.ToLower(code)
+	m := strings.ToLower(message)
+
+	switch {
+	case strings.Contains(c, "context_length") || strings.Contains(m, "context length") ||
+		strings.Contains(m, "maximum context") || strings.Contains(m, "too many tokens") ||
+		strings.Contains(m, "max_prompt_tokens") || strings.Contains(m, "exceeds the model"):
+		return ErrKindContextTooLong
+	case strings.Contains(c, "quota") || strings.Contains(c, "overdue") || strings.Contains(c, "insufficient"):
+		return ErrKindQuota
+	case strings.Contains(c, "ratelimit") || strings.Contains(c, "rate_limit"):
+		return ErrKindRateLimited
+	case strings.Contains(c, "authentication") || strings.Contains(c, "invalid_api_key") ||
+		strings.Contains(c, "accessdenied") || strings.Contains(c, "permission"):
+		return ErrKindAuth
+	case strings.Contains(c, "overloaded") || strings.Contains(c, "serviceunavailable") ||
+		strings.Contains(c, "internalserviceerror"):
+		return ErrKindUpstreamUnavailable
+	}
+
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrKindAuth
+	case status == http.StatusTooManyRequests:
+		return ErrKindRateLimited
+	case status == http.StatusPaymentRequired:
+		return ErrKindQuota
+	case status == http.StatusRequestEntityTooLarge:
+		return ErrKindContextTooLong
+	case status == http.StatusRequestTimeout || status >= 500:
+		return ErrKindUpstreamUnavailable
+	case status >= 400:
+		return ErrKindBadRequest
+	}
+	return ErrKindUnknown
+}
+
+// parseRetryAfter 解析 Retry-After 头，支持秒数与HTTP日期两种格式
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
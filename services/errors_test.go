@@ -0,0 +1,81 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		code    string
+		message string
+		want    ErrorKind
+	}{
+		{name: "上下文超长错误码", status: 400, code: "context_length_exceeded", want: ErrKindContextTooLong},
+		{name: "上下文超长错误信息", status: 400, message: "This model's maximum context length is 8192 tokens", want: ErrKindContextTooLong},
+		{name: "额度耗尽", status: 403, code: "AccountOverdueError", want: ErrKindQuota},
+		{name: "余额不足", status: 400, code: "insufficient_quota", want: ErrKindQuota},
+		{name: "方舟限流错误码", status: 429, code: "RateLimitExceeded.EndpointRPMExceeded", want: ErrKindRateLimited},
+		{name: "OpenAI限流错误码", status: 400, code: "rate_limit_exceeded", want: ErrKindRateLimited},
+		{name: "鉴权错误码优先于状态码", status: 400, code: "AuthenticationError", want: ErrKindAuth},
+		{name: "无效key", status: 400, code: "invalid_api_key", want: ErrKindAuth},
+		{name: "服务过载", status: 400, code: "ServerOverloaded", want: ErrKindUpstreamUnavailable},
+		{name: "401", status: http.StatusUnauthorized, want: ErrKindAuth},
+		{name: "403", status: http.StatusForbidden, want: ErrKindAuth},
+		{name: "429", status: http.StatusTooManyRequests, want: ErrKindRateLimited},
+		{name: "402", status: http.StatusPaymentRequired, want: ErrKindQuota},
+		{name: "413", status: http.StatusRequestEntityTooLarge, want: ErrKindContextTooLong},
+		{name: "408", status: http.StatusRequestTimeout, want: ErrKindUpstreamUnavailable},
+		{name: "502", status: http.StatusBadGateway, want: ErrKindUpstreamUnavailable},
+		{name: "其他4xx", status: http.StatusNotFound, code: "InvalidParameter", want: ErrKindBadRequest},
+		{name: "非错误状态", status: http.StatusOK, want: ErrKindUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.status, tt.code, tt.message); got != tt.want {
+				t.Errorf("classifyError(%d, %q, %q) = %s, want %s", tt.status, tt.code, tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "空", value: "", want: 0},
+		{name: "秒数", value: "3", want: 3 * time.Second},
+		{name: "非法值", value: "soon", want: 0},
+		{name: "已过去的日期", value: "Mon, 02 Jan 2006 15:04:05 GMT", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamErrorRetryable(t *testing.T) {
+	for kind, want := range map[ErrorKind]bool{
+		ErrKindRateLimited:         true,
+		ErrKindUpstreamUnavailable: true,
+		ErrKindAuth:                false,
+		ErrKindQuota:               false,
+		ErrKindContextTooLong:      false,
+		ErrKindBadRequest:          false,
+		ErrKindUnknown:             false,
+	} {
+		if got := (&UpstreamError{Kind: kind}).Retryable(); got != want {
+			t.Errorf("%s: Retryable = %v, want %v", kind, got, want)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 会话导出格式的版本，导入时据此判断能否解析
+const sessionExportVersion = 1
+
+// 导出记录中时间的展示格式
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+// ErrInvalidImport 导入数据不合法
+var ErrInvalidImport = errors.New("导入数据无效")
+
+// SessionExport 会话的导出记录，JSON 格式导出的内容即为此结构，可通过 ImportSession 重新导入。
+// JSON 导出时图片以 data URL 内嵌，不依赖原会话的附件
+type SessionExport struct {
+	Version    int                `json:"version"`
+	ExportedAt time.Time          `json:"exported_at"`
+	Session    models.SessionMeta `json:"session"`
+	Summary    string             `json:"summary,omitempty"`
+	Messages   []models.Message   `json:"messages"`
+}
+
+// ExportSession 导出会话的元信息、摘要与完整历史，inlineImages 为true时把附件展开为 data URL
+func ExportSession(ctx context.Context, sessionID string, inlineImages bool) (SessionExport, error) {
+	meta, err := store.GetSessionMeta(sessionID)
+	if err != nil {
+		return SessionExport{}, err
+	}
+	messages := GetHistory(ctx, sessionID)
+	if inlineImages {
+		messages = resolveAttachments(ctx, sessionID, messages)
+	}
+	return SessionExport{
+		Version:    sessionExportVersion,
+		ExportedAt: time.Now(),
+		Session:    meta,
+		Summary:    GetSummary(ctx, sessionID),
+		Messages:   messages,
+	}, nil
+}
+
+// ImportSession 校验导出记录并以sessionID重新创建会话，会话归属于调用方。
+// 内嵌的图片重新保存为新会话的附件；原会话的用量不随之导入
+func ImportSession(ctx context.Context, exp SessionExport, p models.Principal, sessionID string) (models.SessionMeta, error) {
+	if err := validateImport(exp); err != nil {
+		return models.SessionMeta{}, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	if HasSession(ctx, sessionID) {
+		return models.SessionMeta{}, ErrSessionExists
+	}
+
+	messages, err := importAttachments(ctx, sessionID, exp.Messages)
+	if err != nil {
+		RemoveAttachments(ctx, sessionID)
+		return models.SessionMeta{}, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	now := time.Now()
+	meta := models.SessionMeta{
+		ID:        sessionID,
+		Title:     exp.Session.Title,
+		Role:      exp.Session.Role,
+		Owner:     p.ID,
+		CreatedAt: exp.Session.CreatedAt,
+		UpdatedAt: now,
+	}
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = now
+	}
+	if err := store.ImportSession(meta, messages, exp.Summary); err != nil {
+		if !errors.Is(err, ErrSessionExists) {
+			RemoveAttachments(ctx, sessionID)
+		}
+		return models.SessionMeta{}, err
+	}
+	return store.GetSessionMeta(sessionID)
+}
+
+// validateImport 校验导出记录：版本、角色，以及消息须为 system 开头、用户与助手交替、以助手回复结尾
+func validateImport(exp SessionExport) error {
+	if exp.Version != sessionExportVersion {
+		return fmt.Errorf("不支持的导出格式版本: %d", exp.Version)
+	}
+	if _, ok := GetRole(exp.Session.Role); !ok {
+		return fmt.Errorf("未知角色: %q", exp.Session.Role)
+	}
+	msgs := exp.Messages
+	if len(msgs) == 0 || msgs[0].Role != "system" {
+		return errors.New("首条消息必须为 system 提示词")
+	}
+	if len(msgs)%2 == 0 {
+		return errors.New("对话须以助手回复结尾")
+	}
+
+	var last time.Time
+	for i, m := range msgs {
+		want := "assistant"
+		switch {
+		case i == 0:
+			want = "system"
+		case i%2 == 1:
+			want = "user"
+		}
+		if m.Role != want {
+			return fmt.Errorf("messages[%d]: 应为 %s 消息，实际为 %q", i, want, m.Role)
+		}
+		if len(m.ToolCalls) > 0 || m.ToolCallID != "" {
+			return fmt.Errorf("messages[%d]: 不支持工具调用消息", i)
+		}
+		if i > 0 && strings.TrimSpace(m.Content) == "" && !m.HasImages() {
+			return fmt.Errorf("messages[%d]: 内容为空", i)
+		}
+		if !m.CreatedAt.IsZero() {
+			if m.CreatedAt.Before(last) {
+				return fmt.Errorf("messages[%d]: 时间早于上一条消息", i)
+			}
+			last = m.CreatedAt
+		}
+		if err := validateImportParts(m); err != nil {
+			return fmt.Errorf("messages[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validateImportParts 图片只能出现在用户消息中，须为 data URL 或 http(s) 地址；
+// 附件引用指向原会话的文件，不允许导入
+func validateImportParts(m models.Message) error {
+	images := 0
+	for _, p := range m.Parts {
+		switch p.Type {
+		case models.PartText:
+		case models.PartImage:
+			if m.Role != "user" {
+				return errors.New("只有用户消息可以包含图片")
+			}
+			if p.ImageURL == nil {
+				return errors.New("图片片段缺少 image_url")
+			}
+			u := p.ImageURL.URL
+			if IsAttachmentRef(u) {
+				return errors.New("不能引用原会话的附件，请使用 JSON 导出的内嵌图片")
+			}
+			if !strings.HasPrefix(u, "data:") && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+				return errors.New("图片地址只能是 http(s) 地址或 data URL")
+			}
+			images++
+		default:
+			return fmt.Errorf("不支持的内容片段类型: %q", p.Type)
+		}
+	}
+	if images > config.C.Attachments.MaxImages {
+		return fmt.Errorf("最多附带 %d 张图片", config.C.Attachments.MaxImages)
+	}
+	return nil
+}
+
+// importAttachments 把内嵌的 data URL 图片保存为会话附件，返回替换为附件引用后的消息
+func importAttachments(ctx context.Context, sessionID string, msgs []models.Message) ([]models.Message, error) {
+	out := copyHistory(msgs)
+	for i, m := range out {
+		if !m.HasImages() {
+			continue
+		}
+		parts := make([]models.ContentPart, len(m.Parts))
+		for j, p := range m.Parts {
+			parts[j] = p
+			if p.Type != models.PartImage || !strings.HasPrefix(p.ImageURL.URL, "data:") {
+				continue
+			}
+			img, err := DecodeImage(p.ImageURL.URL)
+			if err != nil {
+				return nil, fmt.Errorf("messages[%d]: %v", i, err)
+			}
+			part, err := SaveAttachment(ctx, sessionID, img)
+			if err != nil {
+				return nil, err
+			}
+			part.ImageURL.Detail = p.ImageURL.Detail
+			parts[j] = part
+		}
+		out[i].Parts = parts
+	}
+	return out, nil
+}
+
+// RenderMarkdown 把导出记录渲染为 Markdown 文本，图片链接指向会话的附件接口
+func RenderMarkdown(exp SessionExport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", exportTitle(exp))
+	fmt.Fprintf(&b, "- 会话ID：`%s`\n", exp.Session.ID)
+	fmt.Fprintf(&b, "- 角色：%s\n", personaLabel(exp.Session.Role))
+	fmt.Fprintf(&b, "- 创建时间：%s\n", formatExportTime(exp.Session.CreatedAt))
+	fmt.Fprintf(&b, "- 导出时间：%s\n", formatExportTime(exp.ExportedAt))
+	if exp.Summary != "" {
+		b.WriteString("\n## 早期对话摘要\n\n")
+		b.WriteString(exp.Summary)
+		b.WriteString("\n")
+	}
+
+	for _, m := range exp.Messages {
+		b.WriteString("\n---\n\n")
+		fmt.Fprintf(&b, "### %s", roleLabel(m.Role))
+		if !m.CreatedAt.IsZero() {
+			fmt.Fprintf(&b, " · %s", formatExportTime(m.CreatedAt))
+		}
+		b.WriteString("\n\n")
+		b.WriteString(m.Content)
+		b.WriteString("\n")
+		n := 0
+		for _, p := range m.Parts {
+			if p.Type != models.PartImage || p.ImageURL == nil {
+				continue
+			}
+			n++
+			fmt.Fprintf(&b, "\n![图片 %d](%s)\n", n, exportImageURL(exp.Session.ID, p.ImageURL.URL))
+		}
+	}
+	return b.String()
+}
+
+// RenderHTML 把导出记录渲染为 HTML 页面，导出时内嵌图片即可得到独立的单个文件
+func RenderHTML(exp SessionExport) ([]byte, error) {
+	type htmlMessage struct {
+		Role    string
+		Label   string
+		Time    string
+		Content string
+		Images  []template.URL
+	}
+	data := struct {
+		Title    string
+		ID       string
+		Role     string
+		Created  string
+		Exported string
+		Summary  string
+		Messages []htmlMessage
+	}{
+		Title:    exportTitle(exp),
+		ID:       exp.Session.ID,
+		Role:     personaLabel(exp.Session.Role),
+		Created:  formatExportTime(exp.Session.CreatedAt),
+		Exported: formatExportTime(exp.ExportedAt),
+		Summary:  exp.Summary,
+	}
+	for _, m := range exp.Messages {
+		hm := htmlMessage{Role: m.Role, Label: roleLabel(m.Role), Content: m.Content}
+		if !m.CreatedAt.IsZero() {
+			hm.Time = formatExportTime(m.CreatedAt)
+		}
+		for _, p := range m.Parts {
+			// 会话中的图片只可能是附件引用、data URL 或 http(s) 地址，可安全地作为图片地址输出
+			if p.Type == models.PartImage && p.ImageURL != nil {
+				hm.Images = append(hm.Images, template.URL(exportImageURL(exp.Session.ID, p.ImageURL.URL)))
+			}
+		}
+		data.Messages = append(data.Messages, hm)
+	}
+
+	var buf bytes.Buffer
+	if err := exportHTML.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var exportHTML = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="UTF-8">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 860px; margin: 24px auto; padding: 0 16px; font-family: -apple-system, "PingFang SC", "Microsoft YaHei", sans-serif; color: #222; }
+.meta { color: #666; font-size: 14px; }
+.message { margin: 16px 0; padding: 12px 16px; border-radius: 8px; background: #f5f5f5; }
+.message.user { background: #e8f0fe; }
+.message.system { background: #fff8e1; }
+.label { font-weight: bold; margin-bottom: 6px; }
+.time { color: #888; font-weight: normal; font-size: 12px; margin-left: 8px; }
+.content { white-space: pre-wrap; word-break: break-word; }
+.message img { display: block; max-width: 100%; margin-top: 8px; border-radius: 6px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">会话ID：{{.ID}}<br>角色：{{.Role}}<br>创建时间：{{.Created}}<br>导出时间：{{.Exported}}</p>
+{{if .Summary}}<h2>早期对话摘要</h2>
+<div class="content">{{.Summary}}</div>
+{{end}}{{range .Messages}}<div class="message {{.Role}}">
+<div class="label">{{.Label}}{{if .Time}}<span class="time">{{.Time}}</span>{{end}}</div>
+<div class="content">{{.Content}}</div>
+{{range .Images}}<img src="{{.}}" alt="图片">
+{{end}}</div>
+{{end}}</body>
+</html>
+`))
+
+func exportTitle(exp SessionExport) string {
+	if exp.Session.Title != "" {
+		return exp.Session.Title
+	}
+	return "未命名会话"
+}
+
+// personaLabel 返回会话角色（人设）的展示名称，角色已不存在时只显示ID
+func personaLabel(id string) string {
+	if r, ok := GetRole(id); ok && r.Name != r.ID {
+		return fmt.Sprintf("%s（%s）", r.Name, r.ID)
+	}
+	return id
+}
+
+func formatExportTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(exportTimeLayout)
+}
+
+// exportImageURL 附件引用转换为附件接口的相对地址，其余地址原样返回
+func exportImageURL(sessionID, u string) string {
+	if name, ok := strings.CutPrefix(u, attachmentScheme); ok {
+		return "/sessions/" + url.PathEscape(sessionID) + "/attachments/" + name
+	}
+	return u
+}
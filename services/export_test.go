@@ -0,0 +1,71 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"testing"
+	"time"
+)
+
+func TestValidateImport(t *testing.T) {
+	withRoles(t, models.Role{ID: "general"}, models.Role{ID: "coder"})
+
+	t0 := time.Date(2024, 5, 20, 10, 0, 0, 0, time.UTC)
+	at := func(m models.Message, d time.Duration) models.Message {
+		m.CreatedAt = t0.Add(d)
+		return m
+	}
+	image := func(url string) models.ContentPart {
+		return models.ContentPart{Type: models.PartImage, ImageURL: &models.ImageURL{URL: url}}
+	}
+	withImage := func(role, url string) models.Message {
+		return models.NewMultipartMessage(role, []models.ContentPart{{Type: models.PartText, Text: "看图"}, image(url)})
+	}
+	sys, u, a := msg("system", "prompt"), msg("user", "hi"), msg("assistant", "hello")
+	var images []models.ContentPart
+	for i := 0; i <= config.C.Attachments.MaxImages; i++ {
+		images = append(images, image("https://example.com/a.png"))
+	}
+
+	tests := []struct {
+		name     string
+		version  int
+		role     string
+		messages []models.Message
+		wantErr  bool
+	}{
+		{name: "合法", messages: []models.Message{sys, u, a}},
+		{name: "只有system", messages: []models.Message{sys}},
+		{name: "时间递增", messages: []models.Message{at(sys, 0), at(u, time.Second), at(a, time.Second)}},
+		{name: "data URL 图片", messages: []models.Message{sys, withImage("user", "data:image/png;base64,AAAA"), a}},
+		{name: "http 图片", messages: []models.Message{sys, withImage("user", "https://example.com/a.png"), a}},
+		{name: "版本不支持", version: 2, messages: []models.Message{sys, u, a}, wantErr: true},
+		{name: "未知角色", role: "nobody", messages: []models.Message{sys, u, a}, wantErr: true},
+		{name: "空消息", messages: nil, wantErr: true},
+		{name: "首条不是system", messages: []models.Message{u, a, u}, wantErr: true},
+		{name: "以用户消息结尾", messages: []models.Message{sys, u, a, u}, wantErr: true},
+		{name: "角色未交替", messages: []models.Message{sys, u, u}, wantErr: true},
+		{name: "工具调用消息", messages: []models.Message{sys, u, {Role: "assistant", Content: "x", ToolCalls: []models.ToolCall{{ID: "1"}}}}, wantErr: true},
+		{name: "内容为空", messages: []models.Message{sys, msg("user", "  "), a}, wantErr: true},
+		{name: "时间倒序", messages: []models.Message{at(sys, time.Minute), at(u, 0), a}, wantErr: true},
+		{name: "助手消息含图片", messages: []models.Message{sys, u, withImage("assistant", "data:image/png;base64,AAAA")}, wantErr: true},
+		{name: "引用原会话附件", messages: []models.Message{sys, withImage("user", "attachment://a.png"), a}, wantErr: true},
+		{name: "图片数超过上限", messages: []models.Message{sys, models.NewMultipartMessage("user", images), a}, wantErr: true},
+		{name: "不支持的图片地址", messages: []models.Message{sys, withImage("user", "file:///etc/passwd"), a}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := SessionExport{Version: sessionExportVersion, Session: models.SessionMeta{Role: "coder"}, Messages: tt.messages}
+			if tt.version != 0 {
+				exp.Version = tt.version
+			}
+			if tt.role != "" {
+				exp.Session.Role = tt.role
+			}
+			if err := validateImport(exp); (err != nil) != tt.wantErr {
+				t.Errorf("validateImport err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,506 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"AiDemo/utils"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 会话私有知识库的名称前缀；共享知识库名称不含 "/"，不会与之冲突
+const sessionKnowledgePrefix = "session/"
+
+// 单次向量化请求最多包含的片段数
+const embedBatchSize = 16
+
+// 向量化产生的用量记在此角色名下
+const embeddingUsageRole = "_embedding"
+
+// 共享知识库名称：字母、数字、下划线与连字符
+var knowledgeNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var (
+	// ErrKnowledgeDisabled 未启用文档检索
+	ErrKnowledgeDisabled = errors.New("未启用文档检索")
+	// ErrDocumentNotFound 文档不存在
+	ErrDocumentNotFound = errors.New("文档不存在")
+	// ErrInvalidDocument 文档内容不合法
+	ErrInvalidDocument = errors.New("文档无效")
+)
+
+// knowledgeChunk 文档片段及其归一化后的向量
+type knowledgeChunk struct {
+	DocID  string    `json:"doc_id"`
+	Index  int       `json:"index"`
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector"`
+}
+
+// knowledgeBase 一个知识库的文档与向量索引，检索时逐一计算余弦相似度
+type knowledgeBase struct {
+	mu        sync.RWMutex
+	name      string
+	Documents []models.KnowledgeDocument `json:"documents"`
+	Chunks    []knowledgeChunk           `json:"chunks"`
+}
+
+// 已加载的知识库
+var (
+	knowledgeBases = make(map[string]*knowledgeBase)
+	knowledgeMu    sync.Mutex
+)
+
+// SessionKnowledgeBase 返回会话私有知识库的名称
+func SessionKnowledgeBase(sessionID string) string {
+	return sessionKnowledgePrefix + base64.RawURLEncoding.EncodeToString([]byte(sessionID))
+}
+
+// ValidKnowledgeBaseName 判断共享知识库名称是否合法
+func ValidKnowledgeBaseName(name string) bool {
+	return knowledgeNameRe.MatchString(name)
+}
+
+// ParseDocument 校验上传的文档并返回规范化后的文本，仅接受 UTF-8 文本（如 txt、Markdown），
+// PDF 等格式需先提取文本
+func ParseDocument(data []byte) (string, error) {
+	if len(data) > config.C.Knowledge.MaxDocumentMB<<20 {
+		return "", fmt.Errorf("%w: 大小超过 %dMB", ErrInvalidDocument, config.C.Knowledge.MaxDocumentMB)
+	}
+	if bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "", fmt.Errorf("%w: 不支持直接上传PDF，请先提取文本", ErrInvalidDocument)
+	}
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("%w: 仅支持 UTF-8 编码的文本", ErrInvalidDocument)
+	}
+	text := strings.TrimPrefix(string(data), "\uFEFF")
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if text == "" {
+		return "", fmt.Errorf("%w: 内容为空", ErrInvalidDocument)
+	}
+	return text, nil
+}
+
+// AddDocument 切分文档并向量化后加入知识库，返回文档及向量化用量（失败时为已消耗的部分）
+func AddDocument(ctx context.Context, base, title, source, text string) (models.KnowledgeDocument, models.Usage, error) {
+	var doc models.KnowledgeDocument
+	var usage models.Usage
+	embedder, model, err := knowledgeEmbedder()
+	if err != nil {
+		return doc, usage, err
+	}
+
+	cfg := config.C.Knowledge
+	texts := splitChunks(text, cfg.ChunkSize, cfg.ChunkOverlap)
+	if len(texts) == 0 {
+		return doc, usage, fmt.Errorf("%w: 内容为空", ErrInvalidDocument)
+	}
+
+	doc = models.KnowledgeDocument{
+		ID:        genDocumentID(),
+		Title:     title,
+		Source:    source,
+		Size:      len(text),
+		Chunks:    len(texts),
+		Model:     model,
+		CreatedAt: time.Now(),
+	}
+	if doc.Title == "" {
+		doc.Title = firstLine(text)
+	}
+
+	// 批量向量化让位于对话请求
+	ctx = WithPriority(ctx, PriorityBatch)
+	chunks := make([]knowledgeChunk, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		res, err := embedder.Embed(ctx, model, texts[start:end])
+		if res.Usage.TotalTokens > 0 {
+			RecordUsage(ctx, baseSessionID(base), embeddingUsageRole, res.Model, res.Usage)
+			usage.Add(res.Usage)
+		}
+		if err != nil {
+			return doc, usage, err
+		}
+		for i, v := range res.Vectors {
+			chunks = append(chunks, knowledgeChunk{DocID: doc.ID, Index: start + i, Text: texts[start+i], Vector: normalize(v)})
+		}
+	}
+
+	kb, err := loadKnowledgeBase(base, true)
+	if err != nil {
+		return doc, usage, err
+	}
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	kb.Documents = append(kb.Documents, doc)
+	kb.Chunks = append(kb.Chunks, chunks...)
+	if err := kb.save(); err != nil {
+		return doc, usage, err
+	}
+	utils.FromContext(ctx).Info("文档已加入知识库(base=%s, doc=%s, chunks=%d)", base, doc.ID, len(chunks))
+	return doc, usage, nil
+}
+
+// ListDocuments 返回知识库中的文档，知识库不存在时返回空列表
+func ListDocuments(base string) ([]models.KnowledgeDocument, error) {
+	kb, err := loadKnowledgeBase(base, false)
+	if err != nil || kb == nil {
+		return []models.KnowledgeDocument{}, err
+	}
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	return append([]models.KnowledgeDocument{}, kb.Documents...), nil
+}
+
+// DeleteDocument 从知识库中删除文档及其片段
+func DeleteDocument(base, docID string) error {
+	kb, err := loadKnowledgeBase(base, false)
+	if err != nil {
+		return err
+	}
+	if kb == nil {
+		return ErrDocumentNotFound
+	}
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	docs := kb.Documents[:0]
+	for _, d := range kb.Documents {
+		if d.ID != docID {
+			docs = append(docs, d)
+		}
+	}
+	if len(docs) == len(kb.Documents) {
+		return ErrDocumentNotFound
+	}
+	kb.Documents = docs
+	chunks := kb.Chunks[:0]
+	for _, c := range kb.Chunks {
+		if c.DocID != docID {
+			chunks = append(chunks, c)
+		}
+	}
+	kb.Chunks = chunks
+	return kb.save()
+}
+
+// RemoveKnowledgeBase 删除整个知识库
+func RemoveKnowledgeBase(ctx context.Context, base string) {
+	knowledgeMu.Lock()
+	defer knowledgeMu.Unlock()
+	delete(knowledgeBases, base)
+	if err := os.Remove(knowledgePath(base)); err != nil && !os.IsNotExist(err) {
+		utils.FromContext(ctx).Warning("删除知识库失败(base=%s): %v", base, err)
+	}
+}
+
+// ListKnowledgeBases 返回所有共享知识库的概况（按名称排序）
+func ListKnowledgeBases() ([]models.KnowledgeBaseInfo, error) {
+	infos := []models.KnowledgeBaseInfo{}
+	entries, err := os.ReadDir(config.C.Knowledge.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return infos, nil
+		}
+		return nil, err
+	}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".json")
+		if e.IsDir() || name == e.Name() || !ValidKnowledgeBaseName(name) {
+			continue
+		}
+		kb, err := loadKnowledgeBase(name, false)
+		if err != nil {
+			return nil, err
+		}
+		if kb == nil {
+			continue
+		}
+		kb.mu.RLock()
+		infos = append(infos, models.KnowledgeBaseInfo{Name: name, Documents: len(kb.Documents), Chunks: len(kb.Chunks)})
+		kb.mu.RUnlock()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Retrieve 在会话私有知识库与指定的共享知识库中检索与query最相关的片段，
+// 返回按相似度降序、编号从1开始的引用及向量化用量。没有可检索的文档时不调用向量化接口
+func Retrieve(ctx context.Context, sessionID string, shared []string, query string) ([]models.Citation, models.Usage, error) {
+	query = strings.TrimSpace(query)
+	if !config.C.Knowledge.Enabled || query == "" {
+		return nil, models.Usage{}, nil
+	}
+
+	type source struct {
+		label string
+		kb    *knowledgeBase
+	}
+	var sources []source
+	add := func(label, base string) error {
+		kb, err := loadKnowledgeBase(base, false)
+		if err != nil || kb == nil {
+			return err
+		}
+		kb.mu.RLock()
+		empty := len(kb.Chunks) == 0
+		kb.mu.RUnlock()
+		if !empty {
+			sources = append(sources, source{label, kb})
+		}
+		return nil
+	}
+	if sessionID != "" {
+		if err := add("session", SessionKnowledgeBase(sessionID)); err != nil {
+			return nil, models.Usage{}, err
+		}
+	}
+	for _, name := range shared {
+		if err := add(name, name); err != nil {
+			return nil, models.Usage{}, err
+		}
+	}
+	if len(sources) == 0 {
+		return nil, models.Usage{}, nil
+	}
+
+	embedder, model, err := knowledgeEmbedder()
+	if err != nil {
+		return nil, models.Usage{}, err
+	}
+	res, err := embedder.Embed(ctx, model, []string{query})
+	if res.Usage.TotalTokens > 0 {
+		RecordUsage(ctx, sessionID, embeddingUsageRole, res.Model, res.Usage)
+	}
+	if err != nil {
+		return nil, res.Usage, err
+	}
+	q := normalize(res.Vectors[0])
+
+	minScore := config.C.Knowledge.MinScore
+	var hits []models.Citation
+	for _, src := range sources {
+		src.kb.mu.RLock()
+		titles := make(map[string]string, len(src.kb.Documents))
+		for _, d := range src.kb.Documents {
+			titles[d.ID] = d.Title
+		}
+		for _, c := range src.kb.Chunks {
+			// 维度不同说明片段由其他向量化模型生成，无法比较
+			if len(c.Vector) != len(q) {
+				continue
+			}
+			score := dot(q, c.Vector)
+			if score < minScore {
+				continue
+			}
+			hits = append(hits, models.Citation{
+				Base:       src.label,
+				DocumentID: c.DocID,
+				Title:      titles[c.DocID],
+				Chunk:      c.Index,
+				Score:      math.Round(score*1e4) / 1e4,
+				Text:       c.Text,
+			})
+		}
+		src.kb.mu.RUnlock()
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > config.C.Knowledge.TopK {
+		hits = hits[:config.C.Knowledge.TopK]
+	}
+	for i := range hits {
+		hits[i].Index = i + 1
+	}
+	utils.FromContext(ctx).Info("检索到 %d 个相关片段(session=%s, bases=%d)", len(hits), sessionID, len(sources))
+	return hits, res.Usage, nil
+}
+
+// KnowledgeMessage 把检索到的片段组织为注入提示词的system消息
+func KnowledgeMessage(citations []models.Citation) models.Message {
+	var b strings.Builder
+	b.WriteString("以下是与用户问题相关的参考资料。回答时优先依据这些资料，并在引用处用 [编号] 标注来源；" +
+		"资料不足以回答时请明确说明，不要编造。\n")
+	for _, c := range citations {
+		fmt.Fprintf(&b, "\n[%d] 《%s》\n%s\n", c.Index, c.Title, c.Text)
+	}
+	return models.Message{Role: "system", Content: b.String()}
+}
+
+// knowledgeEmbedder 返回配置的向量化提供方与模型
+func knowledgeEmbedder() (Embedder, string, error) {
+	if !config.C.Knowledge.Enabled {
+		return nil, "", ErrKnowledgeDisabled
+	}
+	p, err := GetProvider(config.C.Knowledge.Provider)
+	if err != nil {
+		return nil, "", err
+	}
+	e, ok := p.(Embedder)
+	if !ok {
+		return nil, "", fmt.Errorf("提供方 %s 不支持向量化", p.Name())
+	}
+	return e, config.C.Knowledge.EmbeddingModel, nil
+}
+
+// loadKnowledgeBase 返回已加载的知识库，未加载时从文件读取；
+// 文件不存在时create为true则创建空知识库，否则返回nil
+func loadKnowledgeBase(base string, create bool) (*knowledgeBase, error) {
+	knowledgeMu.Lock()
+	defer knowledgeMu.Unlock()
+
+	if kb, ok := knowledgeBases[base]; ok {
+		return kb, nil
+	}
+	kb := &knowledgeBase{name: base}
+	data, err := os.ReadFile(knowledgePath(base))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, kb); err != nil {
+			return nil, fmt.Errorf("解析知识库失败(base=%s): %w", base, err)
+		}
+	case os.IsNotExist(err):
+		if !create {
+			return nil, nil
+		}
+	default:
+		return nil, fmt.Errorf("读取知识库失败(base=%s): %w", base, err)
+	}
+	knowledgeBases[base] = kb
+	return kb, nil
+}
+
+// save 将知识库原子写回磁盘，调用方需持有写锁
+func (kb *knowledgeBase) save() error {
+	path := knowledgePath(kb.name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建知识库目录失败: %w", err)
+	}
+	data, err := json.Marshal(kb)
+	if err != nil {
+		return fmt.Errorf("序列化知识库失败: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("写入知识库文件失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("替换知识库文件失败: %w", err)
+	}
+	return nil
+}
+
+// knowledgePath 知识库文件路径：共享知识库位于目录根部，会话知识库位于 session 子目录
+func knowledgePath(base string) string {
+	return filepath.Join(config.C.Knowledge.Dir, filepath.FromSlash(base)+".json")
+}
+
+// baseSessionID 返回会话私有知识库所属的会话ID，共享知识库返回空串
+func baseSessionID(base string) string {
+	encoded, ok := strings.CutPrefix(base, sessionKnowledgePrefix)
+	if !ok {
+		return ""
+	}
+	id, _ := base64.RawURLEncoding.DecodeString(encoded)
+	return string(id)
+}
+
+// splitChunks 按段落切分文本，每个片段不超过size个字符，相邻片段重叠overlap个字符；
+// 超长段落按固定窗口切分
+func splitChunks(text string, size, overlap int) []string {
+	var chunks []string
+	var cur []rune
+	for _, para := range strings.Split(text, "\n\n") {
+		p := []rune(strings.TrimSpace(para))
+		if len(p) == 0 {
+			continue
+		}
+		if len(cur) > 0 && len(cur)+2+len(p) > size {
+			chunks = append(chunks, string(cur))
+			cur = tailRunes(cur, overlap)
+		}
+		if len(cur) > 0 {
+			cur = append(cur, '\n', '\n')
+		}
+		cur = append(cur, p...)
+		for len(cur) > size {
+			chunks = append(chunks, string(cur[:size]))
+			cur = append([]rune(nil), cur[size-overlap:]...)
+		}
+	}
+	if len(cur) > 0 {
+		chunks = append(chunks, string(cur))
+	}
+	return chunks
+}
+
+// tailRunes 返回末尾n个字符的拷贝
+func tailRunes(r []rune, n int) []rune {
+	if n > len(r) {
+		n = len(r)
+	}
+	return append([]rune(nil), r[len(r)-n:]...)
+}
+
+// normalize 归一化向量，使点积即为余弦相似度
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if sum == 0 {
+		return out
+	}
+	norm := math.Sqrt(sum)
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+func dot(a, b []float32) float64 {
+	var s float64
+	for i := range a {
+		s += float64(a[i]) * float64(b[i])
+	}
+	return s
+}
+
+// firstLine 取文本首行（去掉Markdown标题符号）作为默认标题
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	line = strings.TrimSpace(strings.TrimLeft(line, "# "))
+	if utf8.RuneCountInString(line) > 50 {
+		line = string([]rune(line)[:50]) + "..."
+	}
+	return line
+}
+
+func genDocumentID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("doc%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
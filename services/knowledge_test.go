@@ -0,0 +1,149 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitChunks(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		size, overlap int
+		want          []string
+	}{
+		{name: "空文本", text: "", size: 10, want: nil},
+		{name: "单个段落", text: "abc", size: 10, want: []string{"abc"}},
+		{name: "段落合并", text: "aa\n\nbb", size: 10, want: []string{"aa\n\nbb"}},
+		{name: "空段落与首尾空白", text: "  a \n\n\n\n b", size: 10, want: []string{"a\n\nb"}},
+		{name: "段落切分并重叠", text: "aaaa\n\nbbbb", size: 6, overlap: 2, want: []string{"aaaa", "aa\n\nbb", "bbbb"}},
+		{name: "超长段落按窗口切分", text: "abcdefghij", size: 4, overlap: 1, want: []string{"abcd", "defg", "ghij"}},
+		{name: "按字符而非字节计数", text: "你好世界", size: 2, want: []string{"你好", "世界"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitChunks(tt.text, tt.size, tt.overlap)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitChunks = %q, want %q", got, tt.want)
+			}
+			for _, c := range got {
+				if n := len([]rune(c)); n > tt.size {
+					t.Errorf("片段 %q 长度 %d 超过 %d", c, n, tt.size)
+				}
+			}
+		})
+	}
+}
+
+// fakeEmbedder 按文本中出现的关键词返回固定向量的提供方
+type fakeEmbedder struct {
+	vectors map[string][]float32
+}
+
+func (f *fakeEmbedder) Name() string     { return "test-embed" }
+func (f *fakeEmbedder) Model() ModelInfo { return ModelInfo{Provider: f.Name()} }
+
+func (f *fakeEmbedder) Chat(context.Context, []models.Message, ChatOptions) (ChatResult, error) {
+	return ChatResult{}, errors.New("not implemented")
+}
+
+func (f *fakeEmbedder) ChatStream(context.Context, []models.Message, ChatOptions, func(string) error) (ChatResult, error) {
+	return ChatResult{}, errors.New("not implemented")
+}
+
+func (f *fakeEmbedder) Embed(_ context.Context, model string, inputs []string) (EmbedResult, error) {
+	res := EmbedResult{Model: model}
+	for _, in := range inputs {
+		v := []float32{0, 0, 0, 1}
+		for k, kv := range f.vectors {
+			if strings.Contains(in, k) {
+				v = kv
+			}
+		}
+		res.Vectors = append(res.Vectors, v)
+	}
+	return res, nil
+}
+
+func TestRetrieveRanking(t *testing.T) {
+	const base = "test-ranking"
+	fake := &fakeEmbedder{vectors: map[string][]float32{
+		"apple":  {1, 0, 0, 0},
+		"banana": {0.8, 0.6, 0, 0},
+		"cherry": {0, 0, 2, 0}, // 未归一化的向量
+	}}
+	RegisterProvider(fake)
+	t.Cleanup(func() {
+		providersMu.Lock()
+		delete(providers, fake.Name())
+		providersMu.Unlock()
+		knowledgeMu.Lock()
+		delete(knowledgeBases, base)
+		knowledgeMu.Unlock()
+	})
+	withConfig(t, func(c *config.Config) {
+		c.Knowledge.Enabled = true
+		c.Knowledge.Dir = t.TempDir()
+		c.Knowledge.Provider = fake.Name()
+		c.Knowledge.ChunkSize = 20
+		c.Knowledge.ChunkOverlap = 0
+	})
+
+	doc, _, err := AddDocument(context.Background(), base, "fruits", "", "apple pie recipe\n\nbanana bread\n\ncherry tart")
+	if err != nil {
+		t.Fatalf("AddDocument: %v", err)
+	}
+	if doc.Chunks != 3 {
+		t.Fatalf("doc.Chunks = %d, want 3", doc.Chunks)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		topK     int
+		minScore float64
+		want     []string
+		scores   []float64
+	}{
+		{name: "按相似度降序", query: "apple", topK: 5, minScore: 0.5,
+			want: []string{"apple pie recipe", "banana bread"}, scores: []float64{1, 0.8}},
+		{name: "最低分过滤", query: "apple", topK: 5, minScore: 0.9,
+			want: []string{"apple pie recipe"}, scores: []float64{1}},
+		{name: "取前K个", query: "banana", topK: 1, minScore: 0,
+			want: []string{"banana bread"}, scores: []float64{1}},
+		{name: "同分保持文档顺序", query: "cherry", topK: 3, minScore: 0,
+			want: []string{"cherry tart", "apple pie recipe", "banana bread"}, scores: []float64{1, 0, 0}},
+		{name: "没有足够相似的片段", query: "durian", topK: 3, minScore: 0.5, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *config.Config) {
+				c.Knowledge.TopK = tt.topK
+				c.Knowledge.MinScore = tt.minScore
+			})
+			hits, _, err := Retrieve(context.Background(), "", []string{base}, tt.query)
+			if err != nil {
+				t.Fatalf("Retrieve: %v", err)
+			}
+			var texts []string
+			var scores []float64
+			for i, h := range hits {
+				if h.Index != i+1 || h.Base != base || h.Title != "fruits" {
+					t.Errorf("hits[%d] = %+v", i, h)
+				}
+				texts = append(texts, h.Text)
+				scores = append(scores, h.Score)
+			}
+			if !reflect.DeepEqual(texts, tt.want) || !reflect.DeepEqual(scores, tt.scores) {
+				t.Errorf("Retrieve = %q %v, want %q %v", texts, scores, tt.want, tt.scores)
+			}
+		})
+	}
+}
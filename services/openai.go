@@ -0,0 +1,42 @@
+package services
+
+import (
+	"AiDemo/models"
+	"context"
+	"strings"
+)
+
+// OpenAIProvider OpenAI 兼容协议的提供方（如本地部署的 vLLM、Ollama 等）
+type OpenAIProvider struct {
+	name   string
+	client *chatClient
+}
+
+// NewOpenAIProvider 创建 OpenAI 兼容提供方，baseURL形如 http://localhost:11434/v1
+func NewOpenAIProvider(name, baseURL, apiKey, model string, contextWindow int) *OpenAIProvider {
+	if name == "" {
+		name = "openai"
+	}
+	url := strings.TrimRight(baseURL, "/") + "/chat/completions"
+	return &OpenAIProvider{name: name, client: newChatClient(name, url, apiKey, model, contextWindow)}
+}
+
+func (p *OpenAIProvider) Name() string {
+	return p.name
+}
+
+func (p *OpenAIProvider) Model() ModelInfo {
+	return p.client.modelInfo(p.name)
+}
+
+func (p *OpenAIProvider) Chat(ctx context.Context, messages []models.Message, opts ChatOptions) (ChatResult, error) {
+	return p.client.chat(ctx, messages, opts)
+}
+
+func (p *OpenAIProvider) ChatStream(ctx context.Context, messages []models.Message, opts ChatOptions, onDelta func(delta string) error) (ChatResult, error) {
+	return p.client.chatStream(ctx, messages, opts, onDelta)
+}
+
+func (p *OpenAIProvider) Embed(ctx context.Context, model string, inputs []string) (EmbedResult, error) {
+	return p.client.embed(ctx, model, inputs)
+}
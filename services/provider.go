@@ -0,0 +1,119 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"AiDemo/utils"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultProviderName 未指定时使用的模型提供方
+const DefaultProviderName = "doubao"
+
+// ModelInfo 模型元信息
+type ModelInfo struct {
+	Provider string `json:"provider"` // 提供方名称
+	ID       string `json:"id"`       // 模型ID（或接入点ID）
+	BaseURL  string `json:"base_url"` // 接口地址
+	// ContextWindow 上下文窗口大小（token），0表示使用默认配置
+	ContextWindow int `json:"context_window,omitempty"`
+}
+
+// ChatOptions 单次调用的选项
+type ChatOptions struct {
+	Model  string                  // 为空时使用提供方的默认模型
+	Params models.GenerationParams // 生成参数
+	Tools  []models.Tool           // 允许模型调用的工具，为空时不发送
+}
+
+// ChatResult 一次调用的结果
+type ChatResult struct {
+	Content      string       // 回复内容（流式中断时为已收到的部分）
+	Model        string       // 实际使用的模型
+	Usage        models.Usage // token用量
+	FinishReason string       // 上游返回的结束原因，如 stop、length、tool_calls
+	// ToolCalls 模型请求调用的工具，非空时 Content 通常为空
+	ToolCalls []models.ToolCall
+	// ToolRuns 经 ChatWithTools 执行过的工具调用记录
+	ToolRuns []ToolRun
+}
+
+// Provider 大模型提供方接口
+type Provider interface {
+	// Name 返回提供方名称
+	Name() string
+	// Model 返回当前使用的模型信息
+	Model() ModelInfo
+	// Chat 一次性返回完整回复，ctx取消或超时时中止上游请求
+	Chat(ctx context.Context, messages []models.Message, opts ChatOptions) (ChatResult, error)
+	// ChatStream 流式返回回复，每段增量回调onDelta，最终返回完整回复；
+	// 中途出错或ctx取消时返回已收到的部分内容及错误
+	ChatStream(ctx context.Context, messages []models.Message, opts ChatOptions, onDelta func(delta string) error) (ChatResult, error)
+}
+
+// EmbedResult 一次向量化调用的结果
+type EmbedResult struct {
+	Vectors [][]float32  // 与输入一一对应
+	Model   string       // 实际使用的模型
+	Usage   models.Usage // token用量
+}
+
+// Embedder 支持文本向量化的提供方
+type Embedder interface {
+	// Embed 使用指定模型将inputs逐条向量化
+	Embed(ctx context.Context, model string, inputs []string) (EmbedResult, error)
+}
+
+// 已注册的提供方
+var (
+	providers   = make(map[string]Provider)
+	providersMu sync.RWMutex
+)
+
+// RegisterProvider 注册（或覆盖）一个提供方
+func RegisterProvider(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+// GetProvider 按名称获取提供方，名称为空时返回默认提供方
+func GetProvider(name string) (Provider, error) {
+	if name == "" {
+		name = DefaultProviderName
+	}
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("未知的模型提供方: %s", name)
+	}
+	return p, nil
+}
+
+// ListProviders 返回所有已注册提供方的模型信息（按名称排序）
+func ListProviders() []ModelInfo {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	infos := make([]ModelInfo, 0, len(providers))
+	for _, p := range providers {
+		infos = append(infos, p.Model())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Provider < infos[j].Provider })
+	return infos
+}
+
+// InitProviders 根据配置注册所有可用的提供方
+func InitProviders() {
+	cfg := config.C
+	RegisterProvider(NewDoubaoProvider(cfg.Doubao.APIURL, cfg.Doubao.APIKey, cfg.Doubao.Model, cfg.DoubaoContextWindow()))
+	utils.Info("已注册模型提供方: doubao (model=%s)", cfg.Doubao.Model)
+
+	if cfg.OpenAI.BaseURL != "" {
+		RegisterProvider(NewOpenAIProvider("openai", cfg.OpenAI.BaseURL, cfg.OpenAI.APIKey, cfg.OpenAI.Model, cfg.OpenAIContextWindow()))
+		utils.Info("已注册模型提供方: openai (%s, model=%s)", cfg.OpenAI.BaseURL, cfg.OpenAI.Model)
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"AiDemo/utils"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRoleID 未指定或角色不存在时使用的角色
+const DefaultRoleID = "general"
+
+// 角色注册表，由 LoadRoles 从目录加载，WatchRoles 负责热更新
+var (
+	roles       = make(map[string]models.Role)
+	rolesDir    string
+	rolesStamp  string // 目录内容指纹（文件名+修改时间+大小），用于检测变化
+	rolesLoadMu sync.Mutex
+	rolesMu     sync.RWMutex
+)
+
+// LoadRoles 从目录加载所有角色文件（.md 或 .yaml/.yml），成功后整体替换注册表
+func LoadRoles(dir string) error {
+	rolesLoadMu.Lock()
+	defer rolesLoadMu.Unlock()
+
+	stamp, err := dirStamp(dir)
+	if err != nil {
+		return err
+	}
+	loaded, err := readRoles(dir)
+	if err != nil {
+		return err
+	}
+
+	rolesMu.Lock()
+	roles = loaded
+	rolesDir = dir
+	rolesStamp = stamp
+	rolesMu.Unlock()
+
+	utils.Info("已从 %s 加载 %d 个角色", dir, len(loaded))
+	return nil
+}
+
+// WatchRoles 定期检查角色目录，文件变化时重新加载；重新加载失败时保留原有角色。
+// 返回的函数用于停止监听
+func WatchRoles(interval time.Duration) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				rolesMu.RLock()
+				dir, old := rolesDir, rolesStamp
+				rolesMu.RUnlock()
+
+				stamp, err := dirStamp(dir)
+				if err != nil {
+					utils.Warning("检查角色目录失败: %v", err)
+					continue
+				}
+				if stamp == old {
+					continue
+				}
+				utils.Info("检测到角色文件变化，重新加载...")
+				if err := LoadRoles(dir); err != nil {
+					utils.Error("重新加载角色失败，继续使用原有角色: %v", err)
+				}
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// MaxTokensLimit 返回 max_tokens 允许的上限，即为模型回复预留的token数
+func MaxTokensLimit() int {
+	return config.C.History.CompletionReserve
+}
+
+// GetRole 按ID获取角色
+func GetRole(id string) (models.Role, bool) {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	r, ok := roles[id]
+	return r, ok
+}
+
+// ResolveRole 返回指定角色，不存在时回退为默认角色
+func ResolveRole(id string) models.Role {
+	if r, ok := GetRole(id); ok {
+		return r
+	}
+	r, _ := GetRole(DefaultRoleID)
+	return r
+}
+
+// ListRoles 返回所有角色，按 Order、ID 排序
+func ListRoles() []models.Role {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	list := make([]models.Role, 0, len(roles))
+	for _, r := range roles {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Order != list[j].Order {
+			return list[i].Order < list[j].Order
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// readRoles 解析目录下的全部角色文件，任一文件出错则整体失败
+func readRoles(dir string) (map[string]models.Role, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取角色目录失败: %w", err)
+	}
+
+	loaded := make(map[string]models.Role)
+	for _, e := range entries {
+		if e.IsDir() || !isRoleFile(e.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		r, err := parseRoleFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("解析角色文件 %s 失败: %w", e.Name(), err)
+		}
+		if _, dup := loaded[r.ID]; dup {
+			return nil, fmt.Errorf("角色ID重复: %s", r.ID)
+		}
+		loaded[r.ID] = r
+	}
+
+	if _, ok := loaded[DefaultRoleID]; !ok {
+		return nil, fmt.Errorf("角色目录 %s 中缺少默认角色 %s", dir, DefaultRoleID)
+	}
+	return loaded, nil
+}
+
+// parseRoleFile 解析单个角色文件：
+// .yaml/.yml 直接解析字段；.md 以 --- 包围的YAML头部描述字段，正文作为系统提示词
+func parseRoleFile(path string) (models.Role, error) {
+	var r models.Role
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".md" {
+		front, body, err := splitFrontMatter(data)
+		if err != nil {
+			return r, err
+		}
+		if err := yaml.Unmarshal(front, &r); err != nil {
+			return r, err
+		}
+		if r.SystemPrompt == "" {
+			r.SystemPrompt = strings.TrimSpace(string(body))
+		}
+	} else if err := yaml.Unmarshal(data, &r); err != nil {
+		return r, err
+	}
+
+	if r.ID == "" {
+		r.ID = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if r.Name == "" {
+		r.Name = r.ID
+	}
+	r.SystemPrompt = strings.TrimSpace(r.SystemPrompt)
+	if r.SystemPrompt == "" {
+		return r, fmt.Errorf("系统提示词不能为空")
+	}
+	if problems := r.Params.Validate(MaxTokensLimit()); len(problems) > 0 {
+		return r, fmt.Errorf("生成参数不合法: %s", strings.Join(problems, "; "))
+	}
+	for _, name := range r.Tools {
+		if _, ok := GetTool(name); !ok {
+			return r, fmt.Errorf("未知工具: %s", name)
+		}
+	}
+	for _, name := range r.Knowledge {
+		if !ValidKnowledgeBaseName(name) {
+			return r, fmt.Errorf("知识库名称不合法: %s", name)
+		}
+	}
+	return r, nil
+}
+
+// splitFrontMatter 拆分Markdown的YAML头部与正文
+func splitFrontMatter(data []byte) ([]byte, []byte, error) {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	if !bytes.HasPrefix(data, []byte("---\n")) {
+		return nil, data, nil
+	}
+	rest := data[len("---\n"):]
+	end := bytes.Index(rest, []byte("\n---"))
+	if end < 0 {
+		return nil, nil, fmt.Errorf("YAML头部缺少结束标记 ---")
+	}
+	body := rest[end+len("\n---"):]
+	if i := bytes.IndexByte(body, '\n'); i >= 0 {
+		body = body[i+1:]
+	} else {
+		body = nil
+	}
+	return rest[:end], body, nil
+}
+
+func isRoleFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// dirStamp 计算角色目录的内容指纹
+func dirStamp(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("读取角色目录失败: %w", err)
+	}
+	var sb strings.Builder
+	for _, e := range entries {
+		if e.IsDir() || !isRoleFile(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s|%d|%d;", e.Name(), info.ModTime().UnixNano(), info.Size())
+	}
+	return sb.String(), nil
+}
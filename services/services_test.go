@@ -0,0 +1,40 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"testing"
+)
+
+// withConfig 在测试期间修改全局配置，结束后恢复
+func withConfig(t *testing.T, modify func(c *config.Config)) {
+	t.Helper()
+	old := config.C
+	cfg := *old
+	modify(&cfg)
+	config.C = &cfg
+	t.Cleanup(func() { config.C = old })
+}
+
+// withRoles 在测试期间替换角色注册表，结束后恢复
+func withRoles(t *testing.T, list ...models.Role) {
+	t.Helper()
+	m := make(map[string]models.Role, len(list))
+	for _, r := range list {
+		m[r.ID] = r
+	}
+	rolesMu.Lock()
+	old := roles
+	roles = m
+	rolesMu.Unlock()
+	t.Cleanup(func() {
+		rolesMu.Lock()
+		roles = old
+		rolesMu.Unlock()
+	})
+}
+
+// msg 创建一条文本消息
+func msg(role, content string) models.Message {
+	return models.Message{Role: role, Content: content}
+}
@@ -1,59 +1,393 @@
 package services
 
 import (
+	"AiDemo/config"
 	"AiDemo/models"
-	"sync"
+	"AiDemo/utils"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+	"unicode/utf8"
 )
 
-// 会话历史管理（内存版）
-var (
-	sessionHistories = make(map[string][]models.Message)
-	sessionsMu       sync.RWMutex
-)
+// ErrSessionNotFound 会话不存在
+var ErrSessionNotFound = errors.New("会话不存在")
+
+// ErrSessionForbidden 会话属于其他调用方
+var ErrSessionForbidden = errors.New("无权访问该会话")
+
+// ErrSessionExists 会话ID已被占用
+var ErrSessionExists = errors.New("会话已存在")
+
+// ErrHistoryChanged 会话历史在本轮处理期间已被其他请求修改
+var ErrHistoryChanged = errors.New("会话历史已被其他请求修改，请刷新后重试")
+
+// 自动生成标题时截取首条用户消息的最大字数
+const autoTitleMaxRunes = 20
+
+// SessionStore 会话历史存储接口
+type SessionStore interface {
+	// CreateSession 创建归属于owner的新会话，已存在时不做任何修改并返回false
+	CreateSession(sessionID string, owner string, role string, systemPrompt string) (bool, error)
+	// ImportSession 以完整的元信息、历史与摘要创建会话，ID已存在时返回 ErrSessionExists
+	ImportSession(meta models.SessionMeta, messages []models.Message, summary string) error
+	// ResetSession 用系统提示词重置/初始化指定session的历史，已有会话保留标题与创建时间
+	ResetSession(sessionID string, role string, systemPrompt string) error
+	// AppendMessage 向指定session追加一条消息（追加后按需裁剪）
+	AppendMessage(sessionID string, msg models.Message) error
+	// RewriteHistory 在历史与mark一致时原子地截断到前keep条消息、应用patch（可为nil）并追加msgs，
+	// 历史已变化时返回 ErrHistoryChanged
+	RewriteHistory(sessionID string, mark HistoryMark, keep int, patch *models.SessionPatch, msgs ...models.Message) error
+	// GetHistory 返回指定session的全部历史（拷贝）
+	GetHistory(sessionID string) ([]models.Message, error)
+	// HasSession 判断是否已有该session的历史
+	HasSession(sessionID string) (bool, error)
+	// GetSummary 返回指定session中已被压缩轮次的滚动摘要
+	GetSummary(sessionID string) (string, error)
+	// CompactSession 在历史与mark一致时移除system之后最早的evicted条消息，并以summary替换原摘要，
+	// 历史已变化时返回 ErrHistoryChanged
+	CompactSession(sessionID string, mark HistoryMark, evicted int, summary string) error
+	// ListSessions 返回所有会话的元信息
+	ListSessions() ([]models.SessionMeta, error)
+	// GetSessionMeta 返回指定会话的元信息，不存在时返回 ErrSessionNotFound
+	GetSessionMeta(sessionID string) (models.SessionMeta, error)
+	// UpdateSession 部分更新会话元信息，不存在时返回 ErrSessionNotFound
+	UpdateSession(sessionID string, patch models.SessionPatch) error
+	// DeleteSession 删除会话，不存在时返回 ErrSessionNotFound
+	DeleteSession(sessionID string) error
+	// AddUsage 累加会话的token用量
+	AddUsage(sessionID string, u models.Usage) error
+	// Close 释放存储占用的资源
+	Close() error
+}
+
+// 当前使用的会话存储，默认内存版
+var store SessionStore = NewMemoryStore()
+
+// SetSessionStore 替换会话存储
+func SetSessionStore(s SessionStore) {
+	store = s
+}
+
+// InitSessionStore 根据配置选择会话存储后端
+func InitSessionStore() error {
+	switch config.C.Session.Store {
+	case "", "memory":
+		SetSessionStore(NewMemoryStore())
+		utils.Info("会话存储: memory")
+	case "bolt", "file":
+		if config.C.Session.Store == "file" {
+			utils.Warning("session.store=file 已弃用，会话保存在 bbolt 数据库中，请改为 bolt")
+		}
+		s, err := NewBoltStore(config.C.Session.Dir)
+		if err != nil {
+			return err
+		}
+		SetSessionStore(s)
+		utils.Info("会话存储: bolt (%s)", filepath.Join(config.C.Session.Dir, sessionDBFile))
+	default:
+		return fmt.Errorf("未知的会话存储类型: %s", config.C.Session.Store)
+	}
+	return nil
+}
+
+// CloseSessionStore 关闭当前会话存储
+func CloseSessionStore() {
+	if err := store.Close(); err != nil {
+		utils.Warning("关闭会话存储失败: %v", err)
+	}
+}
 
 // ResetSession 用系统提示词重置/初始化指定session的历史
-func ResetSession(sessionID string, systemPrompt string) {
-	sessionsMu.Lock()
-	defer sessionsMu.Unlock()
-	sessionHistories[sessionID] = []models.Message{{Role: "system", Content: systemPrompt}}
+func ResetSession(ctx context.Context, sessionID string, role string, systemPrompt string) {
+	if err := store.ResetSession(sessionID, role, systemPrompt); err != nil {
+		utils.FromContext(ctx).Error("重置会话失败(session=%s): %v", sessionID, err)
+		return
+	}
+	RemoveAttachments(ctx, sessionID)
+}
+
+// OpenSession 为调用方打开会话：不存在时以指定角色创建并归属于调用方，
+// 已存在但属于其他调用方时返回 ErrSessionForbidden
+func OpenSession(sessionID string, p models.Principal, role string, systemPrompt string) error {
+	created, err := store.CreateSession(sessionID, p.ID, role, systemPrompt)
+	if err != nil || created {
+		return err
+	}
+	_, err = AuthorizeSession(sessionID, p)
+	return err
+}
+
+// AuthorizeSession 返回调用方可访问的会话元信息，
+// 不存在时返回 ErrSessionNotFound，属于其他调用方时返回 ErrSessionForbidden
+func AuthorizeSession(sessionID string, p models.Principal) (models.SessionMeta, error) {
+	meta, err := store.GetSessionMeta(sessionID)
+	if err != nil {
+		return meta, err
+	}
+	if !p.CanAccess(meta) {
+		return models.SessionMeta{}, ErrSessionForbidden
+	}
+	return meta, nil
 }
 
 // AppendMessage 向指定session追加一条消息
-func AppendMessage(sessionID string, msg models.Message) {
-	sessionsMu.Lock()
-	defer sessionsMu.Unlock()
-	sessionHistories[sessionID] = append(sessionHistories[sessionID], msg)
-	trimHistoryIfTooLong(sessionID)
+func AppendMessage(ctx context.Context, sessionID string, msg models.Message) {
+	if err := store.AppendMessage(sessionID, msg); err != nil {
+		utils.FromContext(ctx).Error("追加会话消息失败(session=%s): %v", sessionID, err)
+	}
+}
+
+// HistoryMark 历史的快照标记：消息数与最后一条消息的写入时间，用于检测并发修改
+type HistoryMark struct {
+	Len  int
+	Last time.Time
+}
+
+// MarkHistory 返回历史的快照标记
+func MarkHistory(h []models.Message) HistoryMark {
+	m := HistoryMark{Len: len(h)}
+	if len(h) > 0 {
+		m.Last = h[len(h)-1].CreatedAt
+	}
+	return m
+}
+
+// RewriteHistory 改写会话历史：截断到前keep条消息后追加msgs，历史自mark之后被修改过时返回 ErrHistoryChanged
+func RewriteHistory(sessionID string, mark HistoryMark, keep int, patch *models.SessionPatch, msgs ...models.Message) error {
+	return store.RewriteHistory(sessionID, mark, keep, patch, msgs...)
 }
 
 // GetHistory 返回指定session的全部历史（拷贝）
-func GetHistory(sessionID string) []models.Message {
-	sessionsMu.RLock()
-	defer sessionsMu.RUnlock()
-	h := sessionHistories[sessionID]
-	// 返回一个拷贝，避免外部修改内部切片
+func GetHistory(ctx context.Context, sessionID string) []models.Message {
+	h, err := store.GetHistory(sessionID)
+	if err != nil {
+		utils.FromContext(ctx).Error("读取会话历史失败(session=%s): %v", sessionID, err)
+	}
+	return h
+}
+
+// GetSummary 返回指定session的滚动摘要，没有时返回空串
+func GetSummary(ctx context.Context, sessionID string) string {
+	summary, err := store.GetSummary(sessionID)
+	if err != nil {
+		utils.FromContext(ctx).Error("读取会话摘要失败(session=%s): %v", sessionID, err)
+	}
+	return summary
+}
+
+// HasSession 判断是否已有该session的历史
+func HasSession(ctx context.Context, sessionID string) bool {
+	ok, err := store.HasSession(sessionID)
+	if err != nil {
+		utils.FromContext(ctx).Error("查询会话失败(session=%s): %v", sessionID, err)
+	}
+	return ok
+}
+
+// ListSessions 返回调用方可访问的会话元信息，按更新时间倒序
+func ListSessions(p models.Principal) ([]models.SessionMeta, error) {
+	all, err := store.ListSessions()
+	if err != nil {
+		return nil, err
+	}
+	metas := all[:0]
+	for _, m := range all {
+		if p.CanAccess(m) {
+			metas = append(metas, m)
+		}
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].UpdatedAt.After(metas[j].UpdatedAt) })
+	return metas, nil
+}
+
+// GetSessionMeta 返回指定会话的元信息
+func GetSessionMeta(sessionID string) (models.SessionMeta, error) {
+	return store.GetSessionMeta(sessionID)
+}
+
+// UpdateSession 部分更新会话元信息
+func UpdateSession(sessionID string, patch models.SessionPatch) error {
+	return store.UpdateSession(sessionID, patch)
+}
+
+// DeleteSession 删除会话及其附件与文档
+func DeleteSession(ctx context.Context, sessionID string) error {
+	if err := store.DeleteSession(sessionID); err != nil {
+		return err
+	}
+	RemoveAttachments(ctx, sessionID)
+	RemoveKnowledgeBase(ctx, SessionKnowledgeBase(sessionID))
+	return nil
+}
+
+// DefaultTokenBudget 返回配置中的默认上下文预算
+func DefaultTokenBudget() TokenBudget {
+	return TokenBudget{
+		ContextWindow:        config.C.History.ContextWindow,
+		ReserveForCompletion: config.C.History.CompletionReserve,
+	}
+}
+
+// trimHistory 按默认上下文预算裁剪历史，避免存储无限增长。
+// 摘要模式下由 CompactSession 负责淘汰旧轮次，此处不做裁剪
+func trimHistory(h []models.Message) []models.Message {
+	if SummarizeEnabled() {
+		return h
+	}
+	return FitHistory(h, DefaultTokenBudget())
+}
+
+// compactHistory 移除system之后最早的evicted条消息
+func compactHistory(h []models.Message, evicted int) []models.Message {
+	head := 0
+	if len(h) > 0 && h[0].Role == "system" {
+		head = 1
+	}
+	if evicted <= 0 {
+		return h
+	}
+	if head+evicted > len(h) {
+		evicted = len(h) - head
+	}
+	compacted := make([]models.Message, 0, len(h)-evicted)
+	compacted = append(compacted, h[:head]...)
+	return append(compacted, h[head+evicted:]...)
+}
+
+// PromptHistory 返回发送给指定提供方的历史：摘要模式下在system之后插入对话摘要，
+// 末尾附加尚未写入会话的pending消息，再按其模型的上下文窗口裁剪，最后把附件引用展开为图片数据
+func PromptHistory(ctx context.Context, sessionID string, p Provider, pending ...models.Message) []models.Message {
+	return PromptFromHistory(ctx, sessionID, p, GetHistory(ctx, sessionID), pending...)
+}
+
+// PromptFromHistory 同 PromptHistory，但以给定的历史代替会话当前的历史，用于重新生成或编辑消息
+func PromptFromHistory(ctx context.Context, sessionID string, p Provider, history []models.Message, pending ...models.Message) []models.Message {
+	budget := DefaultTokenBudget()
+	if cw := p.Model().ContextWindow; cw > 0 {
+		budget.ContextWindow = cw
+	}
+	h := withSummary(copyHistory(history), GetSummary(ctx, sessionID))
+	h = FitHistory(append(h, pending...), budget)
+	utils.FromContext(ctx).Debug("发送历史: %d 条消息，约 %d tokens (窗口=%d, 预留=%d)",
+		len(h), EstimateMessagesTokens(h), budget.ContextWindow, budget.ReserveForCompletion)
+	return resolveAttachments(ctx, sessionID, h)
+}
+
+// copyHistory 返回历史的拷贝，避免外部修改内部切片
+func copyHistory(h []models.Message) []models.Message {
 	copied := make([]models.Message, len(h))
 	copy(copied, h)
 	return copied
 }
 
-// HasSession 判断是否已有该session的历史
-func HasSession(sessionID string) bool {
-	sessionsMu.RLock()
-	defer sessionsMu.RUnlock()
-	_, ok := sessionHistories[sessionID]
-	return ok
+// sessionRecord 单个会话的完整数据，供各存储后端共用
+type sessionRecord struct {
+	Meta     models.SessionMeta `json:"meta"`
+	Messages []models.Message   `json:"messages"`
+	Summary  string             `json:"summary,omitempty"`
 }
 
-// 简单长度裁剪，避免历史无限增长（按消息条数裁剪，保留system）
-func trimHistoryIfTooLong(sessionID string) {
-	const maxMessages = 30 // 包含 user/assistant，不含system约束；可按需调整
-	h := sessionHistories[sessionID]
-	if len(h) <= maxMessages+1 { // +1 预留给system
-		return
+// newSessionRecord 创建只包含system提示词的新会话
+func newSessionRecord(sessionID, owner, role, systemPrompt string) *sessionRecord {
+	now := time.Now()
+	return &sessionRecord{
+		Meta: models.SessionMeta{
+			ID:        sessionID,
+			Role:      role,
+			Owner:     owner,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		Messages: []models.Message{{Role: "system", Content: systemPrompt, CreatedAt: now}},
+	}
+}
+
+// reset 清空历史与摘要，保留标题、所有者和创建时间
+func (r *sessionRecord) reset(role, systemPrompt string) {
+	r.Meta.Role = role
+	r.Messages = []models.Message{{Role: "system", Content: systemPrompt, CreatedAt: time.Now()}}
+	r.Summary = ""
+	r.touch()
+}
+
+// append 追加一条消息并按需裁剪，首条用户消息自动作为标题
+func (r *sessionRecord) append(msg models.Message) {
+	if r.Meta.Title == "" && msg.Role == "user" {
+		r.Meta.Title = autoTitle(msg.Content)
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	r.Messages = trimHistory(append(r.Messages, msg))
+	r.touch()
+}
+
+// matches 判断历史是否仍与快照标记一致
+func (r *sessionRecord) matches(mark HistoryMark) bool {
+	return MarkHistory(r.Messages) == mark
+}
+
+// rewrite 截断到前keep条消息，应用元信息更新后追加msgs
+func (r *sessionRecord) rewrite(keep int, patch *models.SessionPatch, msgs []models.Message) {
+	r.Messages = copyHistory(r.Messages[:keep])
+	if patch != nil {
+		r.apply(*patch)
+	}
+	for _, msg := range msgs {
+		r.append(msg)
+	}
+	r.touch()
+}
+
+// compact 移除最早的evicted条消息并更新摘要
+func (r *sessionRecord) compact(evicted int, summary string) {
+	r.Messages = compactHistory(r.Messages, evicted)
+	r.Summary = summary
+	r.touch()
+}
+
+// apply 应用元信息的部分更新
+func (r *sessionRecord) apply(patch models.SessionPatch) {
+	if patch.Title != nil {
+		r.Meta.Title = *patch.Title
+	}
+	if patch.Role != nil {
+		r.Meta.Role = *patch.Role
+	}
+	if patch.SystemPrompt != nil {
+		if len(r.Messages) > 0 && r.Messages[0].Role == "system" {
+			r.Messages[0].Content = *patch.SystemPrompt
+		} else {
+			r.Messages = append([]models.Message{{Role: "system", Content: *patch.SystemPrompt}}, r.Messages...)
+		}
+	}
+	r.touch()
+}
+
+// meta 返回带最新消息数的元信息
+func (r *sessionRecord) meta() models.SessionMeta {
+	m := r.Meta
+	m.MessageCount = 0
+	for _, msg := range r.Messages {
+		if msg.Role != "system" {
+			m.MessageCount++
+		}
+	}
+	return m
+}
+
+func (r *sessionRecord) touch() {
+	r.Meta.UpdatedAt = time.Now()
+}
+
+// autoTitle 截取消息开头作为会话标题
+func autoTitle(content string) string {
+	if utf8.RuneCountInString(content) <= autoTitleMaxRunes {
+		return content
 	}
-	// 保留第0条system，从尾部开始保留最近的maxMessages条
-	start := len(h) - maxMessages
-	trimmed := append([]models.Message{h[0]}, h[start:]...)
-	sessionHistories[sessionID] = trimmed
+	return string([]rune(content)[:autoTitleMaxRunes]) + "..."
 }
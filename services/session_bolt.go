@@ -0,0 +1,529 @@
+package services
+
+import (
+	"AiDemo/models"
+	"AiDemo/utils"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// 会话数据库文件名，位于 config.C.Session.Dir 下
+const sessionDBFile = "sessions.db"
+
+var (
+	sessionsBucket = []byte("sessions") // 顶层桶，其下每个会话一个子桶
+	metaKey        = []byte("meta")     // 会话桶中保存元信息与摘要的键
+	messagesBucket = []byte("messages") // 会话桶中按递增序号保存消息的子桶
+)
+
+// BoltStore 基于嵌入式键值库 bbolt 的会话存储，所有会话保存在同一个数据库文件中。
+// 读操作直接读取数据库，不在内存中常驻会话；追加消息只写入新消息与元信息。
+// 每次修改在一个事务中完成，写入失败时整体回滚，不会出现部分写入
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore 打开（不存在时创建）dir 下的会话数据库，并导入旧版文件存储留下的会话文件
+func NewBoltStore(dir string) (*BoltStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("会话存储目录不能为空")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建会话存储目录失败: %w", err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, sessionDBFile), 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开会话数据库失败: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化会话数据库失败: %w", err)
+	}
+
+	s := &BoltStore{db: db}
+	if n := s.migrateJSON(dir); n > 0 {
+		utils.Info("已将 %d 个旧版会话文件导入 %s", n, sessionDBFile)
+	}
+	return s, nil
+}
+
+// migrateJSON 导入旧版文件存储（每个会话一个JSON文件）的会话，导入后文件改名为 .json.migrated，
+// 数据库中已有同ID会话时跳过。返回导入的数量
+func (s *BoltStore) migrateJSON(dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		utils.Warning("读取会话存储目录失败: %v", err)
+		return 0
+	}
+	n := 0
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			utils.Warning("读取会话文件失败: %s, %v", e.Name(), err)
+			continue
+		}
+		var rec sessionRecord
+		if err := json.Unmarshal(data, &rec); err != nil || rec.Meta.ID == "" {
+			utils.Warning("会话文件格式错误，已跳过: %s", e.Name())
+			continue
+		}
+		err = s.db.Update(func(tx *bolt.Tx) error { return putBoltSession(tx, &rec) })
+		if err != nil && !errors.Is(err, ErrSessionExists) {
+			utils.Warning("导入会话文件失败: %s, %v", e.Name(), err)
+			continue
+		}
+		if err == nil {
+			n++
+		}
+		if err := os.Rename(path, path+".migrated"); err != nil {
+			utils.Warning("重命名已导入的会话文件失败: %s, %v", e.Name(), err)
+		}
+	}
+	return n
+}
+
+func (s *BoltStore) CreateSession(sessionID string, owner string, role string, systemPrompt string) (bool, error) {
+	created := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if sessionBucket(tx, sessionID) != nil {
+			return nil
+		}
+		created = true
+		return putBoltSession(tx, newSessionRecord(sessionID, owner, role, systemPrompt))
+	})
+	return created && err == nil, err
+}
+
+func (s *BoltStore) ImportSession(meta models.SessionMeta, messages []models.Message, summary string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltSession(tx, &sessionRecord{Meta: meta, Messages: messages, Summary: summary})
+	})
+}
+
+func (s *BoltStore) ResetSession(sessionID string, role string, systemPrompt string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bs, err := openBoltSession(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		if bs == nil {
+			return putBoltSession(tx, newSessionRecord(sessionID, "", role, systemPrompt))
+		}
+		bs.rec.reset(role, systemPrompt)
+		return bs.rewriteMessages()
+	})
+}
+
+func (s *BoltStore) AppendMessage(sessionID string, msg models.Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bs, err := openBoltSession(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		if bs == nil {
+			// 兼容未初始化直接追加的情况
+			now := time.Now()
+			rec := &sessionRecord{Meta: models.SessionMeta{ID: sessionID, CreatedAt: now, UpdatedAt: now}}
+			if err := putBoltSession(tx, rec); err != nil {
+				return err
+			}
+			if bs, err = openBoltSession(tx, sessionID); err != nil {
+				return err
+			}
+		}
+		if err := bs.loadMessages(); err != nil {
+			return err
+		}
+		if err := bs.append(msg); err != nil {
+			return err
+		}
+		return bs.saveMeta()
+	})
+}
+
+func (s *BoltStore) RewriteHistory(sessionID string, mark HistoryMark, keep int, patch *models.SessionPatch, msgs ...models.Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bs, err := openBoltSession(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		if bs == nil {
+			return ErrSessionNotFound
+		}
+		if err := bs.loadMessages(); err != nil {
+			return err
+		}
+		if !bs.rec.matches(mark) || keep < 0 || keep > len(bs.rec.Messages) {
+			return ErrHistoryChanged
+		}
+		if err := bs.drop(keep, len(bs.rec.Messages)); err != nil {
+			return err
+		}
+		if patch != nil {
+			if err := bs.apply(*patch); err != nil {
+				return err
+			}
+		}
+		for _, msg := range msgs {
+			if err := bs.append(msg); err != nil {
+				return err
+			}
+		}
+		bs.rec.touch()
+		return bs.saveMeta()
+	})
+}
+
+func (s *BoltStore) GetHistory(sessionID string) ([]models.Message, error) {
+	h := []models.Message{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bs, err := openBoltSession(tx, sessionID)
+		if err != nil || bs == nil {
+			return err
+		}
+		if err := bs.loadMessages(); err != nil {
+			return err
+		}
+		h = bs.rec.Messages
+		return nil
+	})
+	return h, err
+}
+
+func (s *BoltStore) HasSession(sessionID string) (bool, error) {
+	ok := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		ok = sessionBucket(tx, sessionID) != nil
+		return nil
+	})
+	return ok, err
+}
+
+func (s *BoltStore) GetSummary(sessionID string) (string, error) {
+	summary := ""
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bs, err := openBoltSession(tx, sessionID)
+		if err != nil || bs == nil {
+			return err
+		}
+		summary = bs.rec.Summary
+		return nil
+	})
+	return summary, err
+}
+
+func (s *BoltStore) CompactSession(sessionID string, mark HistoryMark, evicted int, summary string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bs, err := openBoltSession(tx, sessionID)
+		if err != nil || bs == nil {
+			return err
+		}
+		if err := bs.loadMessages(); err != nil {
+			return err
+		}
+		if !bs.rec.matches(mark) {
+			return ErrHistoryChanged
+		}
+		// 与 compactHistory 一致：移除system之后最早的evicted条消息
+		head := 0
+		if len(bs.rec.Messages) > 0 && bs.rec.Messages[0].Role == "system" {
+			head = 1
+		}
+		end := head + max(evicted, 0)
+		if end > len(bs.rec.Messages) {
+			end = len(bs.rec.Messages)
+		}
+		if err := bs.drop(head, end); err != nil {
+			return err
+		}
+		bs.rec.Summary = summary
+		bs.rec.touch()
+		return bs.saveMeta()
+	})
+}
+
+func (s *BoltStore) ListSessions() ([]models.SessionMeta, error) {
+	metas := []models.SessionMeta{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEachBucket(func(k []byte) error {
+			bs, err := openBoltSession(tx, string(k))
+			if err != nil {
+				return err
+			}
+			metas = append(metas, bs.rec.Meta)
+			return nil
+		})
+	})
+	return metas, err
+}
+
+func (s *BoltStore) GetSessionMeta(sessionID string) (models.SessionMeta, error) {
+	var meta models.SessionMeta
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bs, err := openBoltSession(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		if bs == nil {
+			return ErrSessionNotFound
+		}
+		meta = bs.rec.Meta
+		return nil
+	})
+	return meta, err
+}
+
+func (s *BoltStore) UpdateSession(sessionID string, patch models.SessionPatch) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bs, err := openBoltSession(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		if bs == nil {
+			return ErrSessionNotFound
+		}
+		if patch.SystemPrompt != nil {
+			if err := bs.loadMessages(); err != nil {
+				return err
+			}
+		}
+		if err := bs.apply(patch); err != nil {
+			return err
+		}
+		return bs.saveMeta()
+	})
+}
+
+func (s *BoltStore) DeleteSession(sessionID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(sessionsBucket).DeleteBucket([]byte(sessionID))
+		if errors.Is(err, bolterrors.ErrBucketNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	})
+}
+
+func (s *BoltStore) AddUsage(sessionID string, u models.Usage) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bs, err := openBoltSession(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		if bs == nil {
+			return ErrSessionNotFound
+		}
+		bs.rec.Meta.Usage.Add(u)
+		return bs.saveMeta()
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// boltMeta 会话桶中 meta 键保存的内容，MessageCount 随消息变更一并更新
+type boltMeta struct {
+	Meta    models.SessionMeta `json:"meta"`
+	Summary string             `json:"summary,omitempty"`
+}
+
+// boltSession 事务内打开的一个会话。消息按需加载，keys 与 rec.Messages 一一对应；
+// 修改方法在更新内存中的 rec 的同时写入对应的键，随事务一并提交或回滚
+type boltSession struct {
+	b      *bolt.Bucket
+	rec    sessionRecord
+	keys   [][]byte
+	loaded bool // rec.Messages 是否已加载
+}
+
+// sessionBucket 返回会话的桶，不存在时返回nil
+func sessionBucket(tx *bolt.Tx, sessionID string) *bolt.Bucket {
+	return tx.Bucket(sessionsBucket).Bucket([]byte(sessionID))
+}
+
+// openBoltSession 打开会话并读取元信息与摘要，不存在时返回nil
+func openBoltSession(tx *bolt.Tx, sessionID string) (*boltSession, error) {
+	b := sessionBucket(tx, sessionID)
+	if b == nil {
+		return nil, nil
+	}
+	var m boltMeta
+	if err := json.Unmarshal(b.Get(metaKey), &m); err != nil {
+		return nil, fmt.Errorf("解析会话元信息失败(session=%s): %w", sessionID, err)
+	}
+	return &boltSession{b: b, rec: sessionRecord{Meta: m.Meta, Summary: m.Summary}}, nil
+}
+
+// putBoltSession 以rec创建会话，已存在时返回 ErrSessionExists
+func putBoltSession(tx *bolt.Tx, rec *sessionRecord) error {
+	b, err := tx.Bucket(sessionsBucket).CreateBucket([]byte(rec.Meta.ID))
+	if errors.Is(err, bolterrors.ErrBucketExists) {
+		return ErrSessionExists
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := b.CreateBucket(messagesBucket); err != nil {
+		return err
+	}
+	bs := &boltSession{b: b, rec: sessionRecord{Meta: rec.Meta, Summary: rec.Summary}, loaded: true}
+	for _, msg := range rec.Messages {
+		if err := bs.push(msg); err != nil {
+			return err
+		}
+	}
+	return bs.saveMeta()
+}
+
+// loadMessages 按序号读取全部消息
+func (s *boltSession) loadMessages() error {
+	if s.loaded {
+		return nil
+	}
+	s.rec.Messages = []models.Message{}
+	c := s.b.Bucket(messagesBucket).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var msg models.Message
+		if err := json.Unmarshal(v, &msg); err != nil {
+			return fmt.Errorf("解析会话消息失败(session=%s): %w", s.rec.Meta.ID, err)
+		}
+		s.rec.Messages = append(s.rec.Messages, msg)
+		s.keys = append(s.keys, append([]byte(nil), k...))
+	}
+	s.loaded = true
+	return nil
+}
+
+// push 在末尾写入一条消息，不做裁剪
+func (s *boltSession) push(msg models.Message) error {
+	key, err := s.nextKey()
+	if err != nil {
+		return err
+	}
+	if err := s.putMessage(key, msg); err != nil {
+		return err
+	}
+	s.rec.Messages = append(s.rec.Messages, msg)
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+// append 同 sessionRecord.append：写入新消息，并删除被裁剪掉的旧消息。
+// 裁剪只移除system之后最早的若干条消息，最后一条（即新消息）总会保留
+func (s *boltSession) append(msg models.Message) error {
+	n := len(s.rec.Messages)
+	head := 0
+	if (n > 0 && s.rec.Messages[0].Role == "system") || (n == 0 && msg.Role == "system") {
+		head = 1
+	}
+	s.rec.append(msg)
+
+	dropped := n + 1 - len(s.rec.Messages)
+	for _, k := range s.keys[head : head+dropped] {
+		if err := s.b.Bucket(messagesBucket).Delete(k); err != nil {
+			return err
+		}
+	}
+	s.keys = append(s.keys[:head:head], s.keys[head+dropped:]...)
+
+	key, err := s.nextKey()
+	if err != nil {
+		return err
+	}
+	s.keys = append(s.keys, key)
+	return s.putMessage(key, s.rec.Messages[len(s.rec.Messages)-1])
+}
+
+// drop 删除下标 [from, to) 的消息
+func (s *boltSession) drop(from, to int) error {
+	for _, k := range s.keys[from:to] {
+		if err := s.b.Bucket(messagesBucket).Delete(k); err != nil {
+			return err
+		}
+	}
+	s.keys = append(s.keys[:from:from], s.keys[to:]...)
+	s.rec.Messages = append(s.rec.Messages[:from:from], s.rec.Messages[to:]...)
+	return nil
+}
+
+// apply 同 sessionRecord.apply，更新system提示词时须已加载消息
+func (s *boltSession) apply(patch models.SessionPatch) error {
+	hadSystem := len(s.rec.Messages) > 0 && s.rec.Messages[0].Role == "system"
+	s.rec.apply(patch)
+	if patch.SystemPrompt == nil {
+		return nil
+	}
+	if hadSystem {
+		return s.putMessage(s.keys[0], s.rec.Messages[0])
+	}
+	// 原历史没有system消息时插入到最前，序号无法前移，整体重写
+	return s.rewriteMessages()
+}
+
+// rewriteMessages 以 rec.Messages 替换已保存的全部消息
+func (s *boltSession) rewriteMessages() error {
+	msgs := s.rec.Messages
+	if err := s.b.DeleteBucket(messagesBucket); err != nil && !errors.Is(err, bolterrors.ErrBucketNotFound) {
+		return err
+	}
+	if _, err := s.b.CreateBucket(messagesBucket); err != nil {
+		return err
+	}
+	s.rec.Messages, s.keys, s.loaded = nil, nil, true
+	for _, msg := range msgs {
+		if err := s.push(msg); err != nil {
+			return err
+		}
+	}
+	return s.saveMeta()
+}
+
+func (s *boltSession) nextKey() ([]byte, error) {
+	seq, err := s.b.Bucket(messagesBucket).NextSequence()
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key, nil
+}
+
+func (s *boltSession) putMessage(key []byte, msg models.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("序列化会话消息失败: %w", err)
+	}
+	return s.b.Bucket(messagesBucket).Put(key, data)
+}
+
+// saveMeta 写入元信息与摘要，已加载消息时同时更新消息数
+func (s *boltSession) saveMeta() error {
+	meta := s.rec.Meta
+	if s.loaded {
+		meta = s.rec.meta()
+	}
+	data, err := json.Marshal(boltMeta{Meta: meta, Summary: s.rec.Summary})
+	if err != nil {
+		return fmt.Errorf("序列化会话元信息失败: %w", err)
+	}
+	return s.b.Put(metaKey, data)
+}
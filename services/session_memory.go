@@ -0,0 +1,174 @@
+package services
+
+import (
+	"AiDemo/models"
+	"sync"
+	"time"
+)
+
+// MemoryStore 内存版会话存储，进程重启后历史丢失
+type MemoryStore struct {
+	sessions map[string]*sessionRecord
+	mu       sync.RWMutex
+}
+
+// NewMemoryStore 创建内存版会话存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]*sessionRecord)}
+}
+
+func (s *MemoryStore) ResetSession(sessionID string, role string, systemPrompt string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.sessions[sessionID]; ok {
+		rec.reset(role, systemPrompt)
+	} else {
+		s.sessions[sessionID] = newSessionRecord(sessionID, "", role, systemPrompt)
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateSession(sessionID string, owner string, role string, systemPrompt string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sessionID]; ok {
+		return false, nil
+	}
+	s.sessions[sessionID] = newSessionRecord(sessionID, owner, role, systemPrompt)
+	return true, nil
+}
+
+func (s *MemoryStore) ImportSession(meta models.SessionMeta, messages []models.Message, summary string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[meta.ID]; ok {
+		return ErrSessionExists
+	}
+	s.sessions[meta.ID] = &sessionRecord{Meta: meta, Messages: copyHistory(messages), Summary: summary}
+	return nil
+}
+
+func (s *MemoryStore) AppendMessage(sessionID string, msg models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.sessions[sessionID]
+	if !ok {
+		// 兼容未初始化直接追加的情况
+		now := time.Now()
+		rec = &sessionRecord{Meta: models.SessionMeta{ID: sessionID, CreatedAt: now, UpdatedAt: now}}
+		s.sessions[sessionID] = rec
+	}
+	rec.append(msg)
+	return nil
+}
+
+func (s *MemoryStore) RewriteHistory(sessionID string, mark HistoryMark, keep int, patch *models.SessionPatch, msgs ...models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.sessions[sessionID]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if !rec.matches(mark) || keep < 0 || keep > len(rec.Messages) {
+		return ErrHistoryChanged
+	}
+	rec.rewrite(keep, patch, msgs)
+	return nil
+}
+
+func (s *MemoryStore) GetHistory(sessionID string) ([]models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.sessions[sessionID]
+	if !ok {
+		return []models.Message{}, nil
+	}
+	return copyHistory(rec.Messages), nil
+}
+
+func (s *MemoryStore) HasSession(sessionID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.sessions[sessionID]
+	return ok, nil
+}
+
+func (s *MemoryStore) GetSummary(sessionID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.sessions[sessionID]
+	if !ok {
+		return "", nil
+	}
+	return rec.Summary, nil
+}
+
+func (s *MemoryStore) CompactSession(sessionID string, mark HistoryMark, evicted int, summary string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	if !rec.matches(mark) {
+		return ErrHistoryChanged
+	}
+	rec.compact(evicted, summary)
+	return nil
+}
+
+func (s *MemoryStore) ListSessions() ([]models.SessionMeta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	metas := make([]models.SessionMeta, 0, len(s.sessions))
+	for _, rec := range s.sessions {
+		metas = append(metas, rec.meta())
+	}
+	return metas, nil
+}
+
+func (s *MemoryStore) GetSessionMeta(sessionID string) (models.SessionMeta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.sessions[sessionID]
+	if !ok {
+		return models.SessionMeta{}, ErrSessionNotFound
+	}
+	return rec.meta(), nil
+}
+
+func (s *MemoryStore) UpdateSession(sessionID string, patch models.SessionPatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.sessions[sessionID]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	rec.apply(patch)
+	return nil
+}
+
+func (s *MemoryStore) DeleteSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sessionID]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+func (s *MemoryStore) AddUsage(sessionID string, u models.Usage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.sessions[sessionID]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	rec.Meta.Usage.Add(u)
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// storeFactories 各会话存储后端，同一组用例在每个后端上运行
+func storeFactories(t *testing.T) map[string]func() SessionStore {
+	return map[string]func() SessionStore{
+		"memory": func() SessionStore { return NewMemoryStore() },
+		"bolt": func() SessionStore {
+			s, err := NewBoltStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewBoltStore: %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+}
+
+// contents 返回各消息的角色与内容
+func contents(h []models.Message) []string {
+	out := make([]string, len(h))
+	for i, m := range h {
+		out[i] = m.Role + ":" + m.Content
+	}
+	return out
+}
+
+func TestSessionStore(t *testing.T) {
+	title := "renamed"
+	tests := []struct {
+		name    string
+		run     func(t *testing.T, s SessionStore) error
+		want    []string
+		summary string
+		wantErr error
+	}{
+		{
+			name: "创建后追加",
+			run: func(t *testing.T, s SessionStore) error {
+				if created, err := s.CreateSession("s", "alice", "general", "sys"); !created || err != nil {
+					t.Fatalf("CreateSession = %v, %v", created, err)
+				}
+				if created, _ := s.CreateSession("s", "bob", "coder", "other"); created {
+					t.Fatal("重复创建返回了 true")
+				}
+				s.AppendMessage("s", msg("user", "q1"))
+				return s.AppendMessage("s", msg("assistant", "a1"))
+			},
+			want: []string{"system:sys", "user:q1", "assistant:a1"},
+		},
+		{
+			name: "未创建直接追加",
+			run: func(t *testing.T, s SessionStore) error {
+				return s.AppendMessage("s", msg("user", "q1"))
+			},
+			want: []string{"user:q1"},
+		},
+		{
+			name: "改写历史",
+			run: func(t *testing.T, s SessionStore) error {
+				s.CreateSession("s", "", "general", "sys")
+				s.AppendMessage("s", msg("user", "q1"))
+				s.AppendMessage("s", msg("assistant", "a1"))
+				h, _ := s.GetHistory("s")
+				return s.RewriteHistory("s", MarkHistory(h), 1, nil, msg("user", "q2"), msg("assistant", "a2"))
+			},
+			want: []string{"system:sys", "user:q2", "assistant:a2"},
+		},
+		{
+			name: "历史已变化时拒绝改写",
+			run: func(t *testing.T, s SessionStore) error {
+				s.CreateSession("s", "", "general", "sys")
+				h, _ := s.GetHistory("s")
+				s.AppendMessage("s", msg("user", "q1"))
+				return s.RewriteHistory("s", MarkHistory(h), 1, nil, msg("user", "q2"))
+			},
+			want:    []string{"system:sys", "user:q1"},
+			wantErr: ErrHistoryChanged,
+		},
+		{
+			name: "切换角色替换system提示词",
+			run: func(t *testing.T, s SessionStore) error {
+				s.CreateSession("s", "", "general", "sys")
+				s.AppendMessage("s", msg("user", "q1"))
+				role, prompt := "coder", "code"
+				return s.UpdateSession("s", models.SessionPatch{Title: &title, Role: &role, SystemPrompt: &prompt})
+			},
+			want: []string{"system:code", "user:q1"},
+		},
+		{
+			name: "没有system消息时插入到最前",
+			run: func(t *testing.T, s SessionStore) error {
+				s.AppendMessage("s", msg("user", "q1"))
+				prompt := "sys"
+				if err := s.UpdateSession("s", models.SessionPatch{SystemPrompt: &prompt}); err != nil {
+					return err
+				}
+				return s.AppendMessage("s", msg("assistant", "a1"))
+			},
+			want: []string{"system:sys", "user:q1", "assistant:a1"},
+		},
+		{
+			name: "压缩",
+			run: func(t *testing.T, s SessionStore) error {
+				s.CreateSession("s", "", "general", "sys")
+				for _, m := range []models.Message{msg("user", "q1"), msg("assistant", "a1"), msg("user", "q2")} {
+					s.AppendMessage("s", m)
+				}
+				h, _ := s.GetHistory("s")
+				return s.CompactSession("s", MarkHistory(h), 2, "summary")
+			},
+			want:    []string{"system:sys", "user:q2"},
+			summary: "summary",
+		},
+		{
+			name: "重置",
+			run: func(t *testing.T, s SessionStore) error {
+				s.CreateSession("s", "", "general", "sys")
+				s.AppendMessage("s", msg("user", "q1"))
+				return s.ResetSession("s", "coder", "code")
+			},
+			want: []string{"system:code"},
+		},
+		{
+			name: "导入已存在的会话",
+			run: func(t *testing.T, s SessionStore) error {
+				s.CreateSession("s", "", "general", "sys")
+				return s.ImportSession(models.SessionMeta{ID: "s"}, []models.Message{msg("system", "x")}, "")
+			},
+			want:    []string{"system:sys"},
+			wantErr: ErrSessionExists,
+		},
+		{
+			name: "删除",
+			run: func(t *testing.T, s SessionStore) error {
+				s.CreateSession("s", "", "general", "sys")
+				if err := s.DeleteSession("s"); err != nil {
+					return err
+				}
+				if ok, _ := s.HasSession("s"); ok {
+					t.Error("删除后会话仍存在")
+				}
+				return s.DeleteSession("s")
+			},
+			want:    []string{},
+			wantErr: ErrSessionNotFound,
+		},
+	}
+
+	for name, newStore := range storeFactories(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				s := newStore()
+				if err := tt.run(t, s); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				h, err := s.GetHistory("s")
+				if err != nil {
+					t.Fatalf("GetHistory: %v", err)
+				}
+				if got := contents(h); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("history = %q, want %q", got, tt.want)
+				}
+				if summary, _ := s.GetSummary("s"); summary != tt.summary {
+					t.Errorf("summary = %q, want %q", summary, tt.summary)
+				}
+				if meta, err := s.GetSessionMeta("s"); err == nil {
+					want := 0
+					for _, m := range tt.want {
+						if !strings.HasPrefix(m, "system:") {
+							want++
+						}
+					}
+					if meta.MessageCount != want {
+						t.Errorf("MessageCount = %d, want %d", meta.MessageCount, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSessionStoreTrim(t *testing.T) {
+	// 窗口只容纳system与最近一轮，追加时裁剪掉更早的轮次
+	withConfig(t, func(c *config.Config) {
+		c.History.Mode = "trim"
+		c.History.ContextWindow = 40
+		c.History.CompletionReserve = 0
+	})
+	for name, newStore := range storeFactories(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			s.CreateSession("s", "", "general", "sys")
+			for i := 0; i < 3; i++ {
+				n := string(rune('1' + i))
+				s.AppendMessage("s", msg("user", "question "+n))
+				s.AppendMessage("s", msg("assistant", "answer "+n))
+			}
+			h, _ := s.GetHistory("s")
+			want := []string{"system:sys", "user:question 3", "assistant:answer 3"}
+			if got := contents(h); !reflect.DeepEqual(got, want) {
+				t.Errorf("history = %q, want %q", got, want)
+			}
+			if meta, _ := s.GetSessionMeta("s"); meta.MessageCount != 2 || !strings.HasPrefix(meta.Title, "question 1") {
+				t.Errorf("meta = %+v", meta)
+			}
+		})
+	}
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewBoltStore(dir)
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	s.CreateSession("s", "alice", "general", "sys")
+	s.AppendMessage("s", msg("user", "q1"))
+	s.AddUsage("s", models.Usage{TotalTokens: 15})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err = NewBoltStore(dir)
+	if err != nil {
+		t.Fatalf("重新打开: %v", err)
+	}
+	defer s.Close()
+	h, _ := s.GetHistory("s")
+	if got, want := contents(h), []string{"system:sys", "user:q1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+	meta, err := s.GetSessionMeta("s")
+	if err != nil || meta.Owner != "alice" || meta.Usage.TotalTokens != 15 || meta.MessageCount != 1 {
+		t.Errorf("meta = %+v, %v", meta, err)
+	}
+	if metas, _ := s.ListSessions(); len(metas) != 1 {
+		t.Errorf("ListSessions = %d 个, want 1", len(metas))
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"AiDemo/utils"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 历史处理模式
+const (
+	HistoryModeTrim      = "trim"      // 超出预算的旧轮次直接丢弃
+	HistoryModeSummarize = "summarize" // 超出预算的旧轮次压缩为滚动摘要
+)
+
+const summarySystemPrompt = "你是对话摘要助手。请把已有摘要与新增的对话内容合并为一份更新后的摘要。" +
+	"要求：1) 保留用户的目标、需求、约束、已确认的结论与待办事项；2) 保留关键数据、名称与代码要点；" +
+	"3) 删除寒暄与重复内容；4) 使用简洁的中文分点表述；5) 只输出摘要本身。"
+
+// 正在压缩中的会话，避免同一会话并发压缩
+var compacting sync.Map
+
+// SummarizeEnabled 是否启用摘要压缩模式
+func SummarizeEnabled() bool {
+	return config.C.History.Mode == HistoryModeSummarize
+}
+
+// withSummary 在system提示词之后插入对话摘要消息
+func withSummary(h []models.Message, summary string) []models.Message {
+	if summary == "" {
+		return h
+	}
+	head := 0
+	if len(h) > 0 && h[0].Role == "system" {
+		head = 1
+	}
+	msg := models.Message{Role: "system", Content: "以下是此前对话的摘要，请结合摘要继续对话：\n" + summary}
+	merged := make([]models.Message, 0, len(h)+1)
+	merged = append(merged, h[:head]...)
+	merged = append(merged, msg)
+	return append(merged, h[head:]...)
+}
+
+// CompactSession 在摘要模式下检查会话是否超出预算，
+// 若超出则把即将淘汰的轮次交给模型并入滚动摘要，再从历史中移除。
+// 生成摘要期间历史被修改（如重置、重新生成）时放弃本次压缩，留待下一轮。
+// ctx 仅用于传递日志记录器，摘要请求不随其取消，而以单轮对话时限（upstream.request_timeout）为限，
+// 超时则放弃本次压缩，避免上游无响应时一直占用会话的对话锁
+func CompactSession(ctx context.Context, sessionID string, p Provider) {
+	if !SummarizeEnabled() {
+		return
+	}
+	if _, busy := compacting.LoadOrStore(sessionID, struct{}{}); busy {
+		return
+	}
+	defer compacting.Delete(sessionID)
+
+	h := GetHistory(ctx, sessionID)
+	mark := MarkHistory(h)
+	summary := GetSummary(ctx, sessionID)
+
+	// 为摘要预留空间后，计算历史中需要淘汰的消息
+	budget := DefaultTokenBudget()
+	budget.ContextWindow -= config.C.History.SummaryMaxTokens
+	kept := FitHistory(h, budget)
+	evicted := len(h) - len(kept)
+	if evicted <= 0 {
+		return
+	}
+
+	head := 0
+	if h[0].Role == "system" {
+		head = 1
+	}
+	log := utils.FromContext(ctx)
+	log.Info("会话超出上下文预算，开始压缩 %d 条消息(session=%s)", evicted, sessionID)
+
+	ctx, cancel := context.WithTimeout(WithPriority(context.WithoutCancel(ctx), PriorityBatch), config.C.RequestTimeout())
+	defer cancel()
+	result, err := summarize(ctx, p, summary, h[head:head+evicted])
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Warning("生成会话摘要超时，放弃本次压缩(session=%s)", sessionID)
+		} else {
+			log.Error("生成会话摘要失败(session=%s): %v", sessionID, err)
+		}
+		return
+	}
+
+	RecordUsage(ctx, sessionID, summaryUsageRole, result.Model, result.Usage)
+	newSummary := result.Content
+
+	if err := store.CompactSession(sessionID, mark, evicted, newSummary); err != nil {
+		if errors.Is(err, ErrHistoryChanged) {
+			log.Info("会话历史在压缩期间已被修改，放弃本次压缩(session=%s)", sessionID)
+			return
+		}
+		log.Error("保存会话摘要失败(session=%s): %v", sessionID, err)
+		return
+	}
+	log.Info("会话压缩完成(session=%s)，摘要长度: %d", sessionID, len(newSummary))
+}
+
+// summarize 基于已有摘要与被淘汰的消息增量生成新摘要
+func summarize(ctx context.Context, p Provider, summary string, evicted []models.Message) (ChatResult, error) {
+	var sb strings.Builder
+	if summary != "" {
+		sb.WriteString("已有摘要：\n")
+		sb.WriteString(summary)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("新增对话：\n")
+	for _, m := range evicted {
+		sb.WriteString(roleLabel(m.Role))
+		sb.WriteString("：")
+		sb.WriteString(m.Content)
+		sb.WriteString("\n")
+	}
+	sb.WriteString(fmt.Sprintf("\n请输出更新后的摘要，长度不超过 %d 个token。", config.C.History.SummaryMaxTokens))
+
+	return p.Chat(ctx, []models.Message{
+		{Role: "system", Content: summarySystemPrompt},
+		{Role: "user", Content: sb.String()},
+	}, ChatOptions{})
+}
+
+// roleLabel 返回角色的中文标签
+func roleLabel(role string) string {
+	switch role {
+	case "user":
+		return "用户"
+	case "assistant":
+		return "助手"
+	case "system":
+		return "系统"
+	default:
+		return role
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"AiDemo/models"
+	"unicode"
+)
+
+// 每条消息在角色、分隔符等格式上的额外开销（估算值）
+const messageTokenOverhead = 4
+
+// 每张图片的token估算值，实际按分辨率计费，这里取常见尺寸的上限
+const imageTokenEstimate = 1024
+
+// EstimateTokens 粗略估算文本的token数，适用于中英文混合文本：
+// 汉字、假名、韩文等按每字1个token计，其余ASCII字符约每4个计1个token，
+// 其他非ASCII字符（如emoji、全角标点）按每字1个token计，宁多勿少。
+func EstimateTokens(text string) int {
+	tokens := 0
+	ascii := 0
+	for _, r := range text {
+		switch {
+		case r <= unicode.MaxASCII:
+			ascii++
+		default:
+			tokens++
+		}
+	}
+	return tokens + (ascii+3)/4
+}
+
+// EstimateMessageTokens 估算单条消息的token数
+func EstimateMessageTokens(msg models.Message) int {
+	tokens := messageTokenOverhead + EstimateTokens(msg.Role) + EstimateTokens(msg.Content)
+	for _, p := range msg.Parts {
+		if p.Type == models.PartImage {
+			tokens += imageTokenEstimate
+		}
+	}
+	for _, call := range msg.ToolCalls {
+		tokens += EstimateTokens(call.Function.Name) + EstimateTokens(call.Function.Arguments)
+	}
+	return tokens
+}
+
+// EstimateMessagesTokens 估算一组消息的token总数
+func EstimateMessagesTokens(messages []models.Message) int {
+	total := 0
+	for _, m := range messages {
+		total += EstimateMessageTokens(m)
+	}
+	return total
+}
+
+// TokenBudget 单次请求的上下文预算
+type TokenBudget struct {
+	ContextWindow        int // 模型上下文窗口大小
+	ReserveForCompletion int // 为模型回复预留的token数
+}
+
+// HistoryLimit 返回可用于历史消息的token数
+func (b TokenBudget) HistoryLimit() int {
+	limit := b.ContextWindow - b.ReserveForCompletion
+	if limit < 0 {
+		return 0
+	}
+	return limit
+}
+
+// FitHistory 保留system提示词以及预算内尽可能多的最近轮次。
+// 裁剪以user消息为轮次边界，避免保留没有提问的孤立回复；
+// 保留部分总是从user消息开始，最后一轮无论多长都会完整保留，保证当前提问能够发出。
+func FitHistory(h []models.Message, budget TokenBudget) []models.Message {
+	if len(h) == 0 || budget.ContextWindow <= 0 {
+		return h
+	}
+
+	head := 0
+	if h[0].Role == "system" {
+		head = 1
+	}
+	if len(h) <= head {
+		return h
+	}
+
+	remaining := budget.HistoryLimit() - EstimateMessagesTokens(h[:head])
+	start := len(h) - 1
+	remaining -= EstimateMessageTokens(h[start])
+	for start > head {
+		cost := EstimateMessageTokens(h[start-1])
+		if cost > remaining {
+			break
+		}
+		remaining -= cost
+		start--
+	}
+	if start == head {
+		return h
+	}
+
+	// 向后移动到下一个user消息，保证保留的部分从完整轮次开始；
+	// 之后没有user消息时说明最后一轮本身超出预算，向前退到该轮的user消息，完整保留最后一轮
+	next := -1
+	for i := start; i < len(h); i++ {
+		if h[i].Role == "user" {
+			next = i
+			break
+		}
+	}
+	for i := start - 1; next < 0 && i >= head; i-- {
+		if h[i].Role == "user" {
+			next = i
+		}
+	}
+	if next <= head {
+		return h
+	}
+	start = next
+
+	trimmed := make([]models.Message, 0, head+len(h)-start)
+	trimmed = append(trimmed, h[:head]...)
+	return append(trimmed, h[start:]...)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"AiDemo/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "空串", text: "", want: 0},
+		{name: "单个ASCII字符", text: "a", want: 1},
+		{name: "四个ASCII字符", text: "abcd", want: 1},
+		{name: "五个ASCII字符", text: "abcde", want: 2},
+		{name: "汉字每字一个", text: "你好世界", want: 4},
+		{name: "中英混合", text: "你好 world", want: 2 + 2},
+		{name: "假名与韩文", text: "こんにちは안녕", want: 7},
+		{name: "emoji与全角标点", text: "👍，", want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateTokens(tt.text); got != tt.want {
+				t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateMessageTokens(t *testing.T) {
+	image := models.ContentPart{Type: models.PartImage, ImageURL: &models.ImageURL{URL: "attachment://a.png"}}
+	tests := []struct {
+		name string
+		msg  models.Message
+		want int
+	}{
+		{name: "文本消息", msg: msg("user", "abcd"), want: messageTokenOverhead + 1 + 1},
+		{
+			name: "图片按固定值估算",
+			msg:  models.NewMultipartMessage("user", []models.ContentPart{{Type: models.PartText, Text: "abcd"}, image, image}),
+			want: messageTokenOverhead + 1 + 1 + 2*imageTokenEstimate,
+		},
+		{
+			name: "工具调用计入名称与参数",
+			msg: models.Message{Role: "assistant", ToolCalls: []models.ToolCall{
+				{Function: models.FunctionCall{Name: "calc", Arguments: `{"x":1}`}},
+			}},
+			want: messageTokenOverhead + 3 + 1 + 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateMessageTokens(tt.msg); got != tt.want {
+				t.Errorf("EstimateMessageTokens = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitHistory(t *testing.T) {
+	sys := msg("system", "be helpful")
+	u1, a1 := msg("user", "question one"), msg("assistant", "answer one")
+	u2, a2 := msg("user", "question two"), msg("assistant", "answer two")
+	long := msg("assistant", strings.Repeat("x", 400))
+
+	// budget 返回恰好容纳msgs的预算
+	budget := func(msgs ...models.Message) TokenBudget {
+		return TokenBudget{ContextWindow: EstimateMessagesTokens(msgs) + 100, ReserveForCompletion: 100}
+	}
+
+	tests := []struct {
+		name    string
+		history []models.Message
+		budget  TokenBudget
+		want    []models.Message
+	}{
+		{name: "空历史", history: []models.Message{}, budget: budget(), want: []models.Message{}},
+		{
+			name:    "未设置窗口时不裁剪",
+			history: []models.Message{sys, u1, a1, u2, a2},
+			budget:  TokenBudget{},
+			want:    []models.Message{sys, u1, a1, u2, a2},
+		},
+		{name: "只有system", history: []models.Message{sys}, budget: budget(), want: []models.Message{sys}},
+		{
+			name:    "预算充足",
+			history: []models.Message{sys, u1, a1, u2, a2},
+			budget:  budget(sys, u1, a1, u2, a2),
+			want:    []models.Message{sys, u1, a1, u2, a2},
+		},
+		{
+			name:    "丢弃最早的轮次",
+			history: []models.Message{sys, u1, a1, u2, a2},
+			budget:  budget(sys, u2, a2),
+			want:    []models.Message{sys, u2, a2},
+		},
+		{
+			name:    "不保留没有提问的孤立回复",
+			history: []models.Message{sys, u1, a1, u2, a2},
+			budget:  budget(sys, a1, u2, a2),
+			want:    []models.Message{sys, u2, a2},
+		},
+		{
+			name:    "没有system消息",
+			history: []models.Message{u1, a1, u2, a2},
+			budget:  budget(u2, a2),
+			want:    []models.Message{u2, a2},
+		},
+		{
+			name:    "最后一条超出预算时仍保留",
+			history: []models.Message{sys, u1, a1, u2},
+			budget:  budget(sys),
+			want:    []models.Message{sys, u2},
+		},
+		{
+			name:    "之后没有user消息时保留完整的最后一轮",
+			history: []models.Message{sys, u1, a1, u2, long},
+			budget:  budget(sys, u2),
+			want:    []models.Message{sys, u2, long},
+		},
+		{
+			name:    "历史中没有user消息时不裁剪",
+			history: []models.Message{sys, a1, long},
+			budget:  budget(sys),
+			want:    []models.Message{sys, a1, long},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FitHistory(tt.history, tt.budget)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FitHistory = %v, want %v", describe(got), describe(tt.want))
+			}
+		})
+	}
+}
+
+// describe 返回各消息的角色与内容开头，便于输出对比
+func describe(msgs []models.Message) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		c := m.Content
+		if len(c) > 12 {
+			c = c[:12]
+		}
+		out[i] = m.Role + ":" + c
+	}
+	return out
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"AiDemo/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 单个工具执行的最长时间
+const toolTimeout = 10 * time.Second
+
+// 工具结果回传给模型时的最大字节数
+const maxToolOutput = 8 * 1024
+
+// ToolContext 工具执行时可用的上下文
+type ToolContext struct {
+	SessionID string           // 当前会话，无状态调用时为空
+	History   []models.Message // 当前会话历史，供历史检索等工具使用
+}
+
+// ToolHandler 工具实现，args为模型给出的JSON参数，返回值作为工具结果回传给模型
+type ToolHandler func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error)
+
+// Tool 服务端工具：名称、说明、参数的 JSON Schema 与实现
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+	Handler     ToolHandler
+}
+
+// ToolRun 一次工具调用的记录
+type ToolRun struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// output 返回回传给模型的工具结果
+func (r ToolRun) output() string {
+	if r.Error != "" {
+		return "工具调用失败: " + r.Error
+	}
+	return r.Result
+}
+
+// 已注册的工具
+var (
+	tools   = make(map[string]Tool)
+	toolsMu sync.RWMutex
+)
+
+// RegisterTool 注册（或覆盖）一个工具
+func RegisterTool(t Tool) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	tools[t.Name] = t
+}
+
+// GetTool 按名称获取工具
+func GetTool(name string) (Tool, bool) {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	t, ok := tools[name]
+	return t, ok
+}
+
+// ListTools 返回所有已注册工具的名称（排序）
+func ListTools() []string {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ToolDefinitions 返回指定工具发送给模型的定义，未启用工具调用时返回nil
+func ToolDefinitions(ctx context.Context, names []string) []models.Tool {
+	if !config.C.Tools.Enabled || len(names) == 0 {
+		return nil
+	}
+	defs := make([]models.Tool, 0, len(names))
+	for _, name := range names {
+		t, ok := GetTool(name)
+		if !ok {
+			utils.FromContext(ctx).Warning("未注册的工具: %s", name)
+			continue
+		}
+		defs = append(defs, models.Tool{
+			Type:     "function",
+			Function: models.FunctionDef{Name: t.Name, Description: t.Description, Parameters: t.Parameters},
+		})
+	}
+	return defs
+}
+
+// ChatWithTools 调用模型并执行其请求的工具，把结果回传给模型，直到得到最终回复。
+// 超过 config.C.Tools.MaxRounds 轮后不再提供工具，要求模型直接作答。
+// onDelta 非nil时使用流式调用；onTool 非nil时在每个工具执行后回调。
+// 返回的结果中 Content 为各轮文本之和，Usage 为各轮用量之和
+func ChatWithTools(ctx context.Context, p Provider, messages []models.Message, opts ChatOptions, tc ToolContext,
+	onDelta func(delta string) error, onTool func(run ToolRun)) (ChatResult, error) {
+	msgs := append([]models.Message(nil), messages...)
+	var total ChatResult
+	var content strings.Builder
+
+	for round := 0; ; round++ {
+		roundOpts := opts
+		if round >= config.C.Tools.MaxRounds {
+			roundOpts.Tools = nil
+		}
+
+		var res ChatResult
+		var err error
+		if onDelta != nil {
+			res, err = p.ChatStream(ctx, msgs, roundOpts, onDelta)
+		} else {
+			res, err = p.Chat(ctx, msgs, roundOpts)
+		}
+		content.WriteString(res.Content)
+		total.Content = content.String()
+		total.Model = res.Model
+		total.FinishReason = res.FinishReason
+		total.Usage.Add(res.Usage)
+		if err != nil || len(res.ToolCalls) == 0 || len(roundOpts.Tools) == 0 {
+			return total, err
+		}
+
+		utils.FromContext(ctx).Info("模型请求调用 %d 个工具(session=%s, round=%d)", len(res.ToolCalls), tc.SessionID, round+1)
+		msgs = append(msgs, models.Message{Role: "assistant", Content: res.Content, ToolCalls: res.ToolCalls})
+		for _, call := range res.ToolCalls {
+			run := runTool(ctx, tc, call)
+			total.ToolRuns = append(total.ToolRuns, run)
+			if onTool != nil {
+				onTool(run)
+			}
+			msgs = append(msgs, models.Message{Role: "tool", ToolCallID: call.ID, Content: run.output()})
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// runTool 执行单个工具调用，错误记录在结果中回传给模型而不中断对话
+func runTool(ctx context.Context, tc ToolContext, call models.ToolCall) ToolRun {
+	log := utils.FromContext(ctx)
+	run := ToolRun{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments}
+
+	t, ok := GetTool(call.Function.Name)
+	if !ok {
+		run.Error = fmt.Sprintf("未知工具: %s", call.Function.Name)
+		log.Warning("模型请求了未知工具: %s", call.Function.Name)
+		return run
+	}
+
+	args := json.RawMessage(call.Function.Arguments)
+	if strings.TrimSpace(call.Function.Arguments) == "" {
+		args = json.RawMessage("{}")
+	}
+	if !json.Valid(args) {
+		run.Error = "参数不是合法的JSON"
+		return run
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, toolTimeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := t.Handler(ctx, tc, args)
+	if err != nil {
+		run.Error = err.Error()
+		log.Warning("工具 %s 执行失败: %v", t.Name, err)
+		return run
+	}
+	if len(result) > maxToolOutput {
+		result = truncateUTF8(result, maxToolOutput) + "...(已截断)"
+	}
+	run.Result = result
+	log.Info("工具 %s 执行完成，耗时 %v", t.Name, time.Since(start))
+	return run
+}
+
+// truncateUTF8 截断到不超过n字节，且不切断多字节字符
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
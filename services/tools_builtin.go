@@ -0,0 +1,402 @@
+package services
+
+import (
+	"AiDemo/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // 保证容器中缺少时区数据库时 current_time 仍可用
+	"unicode"
+	"unicode/utf8"
+)
+
+// 内置工具名称
+const (
+	ToolCurrentTime   = "current_time"
+	ToolCalculator    = "calculator"
+	ToolSearchHistory = "search_history"
+)
+
+// 内置工具的限制
+const (
+	maxExpressionLength  = 256
+	maxExpressionDepth   = 64
+	defaultSearchResults = 5
+	maxSearchResults     = 20
+	searchSnippetRunes   = 200
+)
+
+// InitTools 注册内置工具，均为只读、无副作用的安全工具
+func InitTools() {
+	RegisterTool(Tool{
+		Name:        ToolCurrentTime,
+		Description: "获取当前日期、时间与星期，可指定IANA时区（如 Asia/Shanghai），默认服务器本地时区",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"timezone":{"type":"string","description":"IANA时区名，如 Asia/Shanghai、America/New_York"}}}`),
+		Handler: currentTimeTool,
+	})
+	RegisterTool(Tool{
+		Name:        ToolCalculator,
+		Description: "计算数学表达式，支持 + - * / % ^、括号，函数 sqrt abs round floor ceil ln log10 sin cos tan，常量 pi e",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"expression":{"type":"string","description":"要计算的表达式，如 (3+4)*2^10"}},"required":["expression"]}`),
+		Handler: calculatorTool,
+	})
+	RegisterTool(Tool{
+		Name:        ToolSearchHistory,
+		Description: "在当前会话的历史消息中按关键词检索，返回匹配的消息片段",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"query":{"type":"string","description":"关键词，不区分大小写"},` +
+			`"limit":{"type":"integer","description":"最多返回的条数，默认5，最大20"}},"required":["query"]}`),
+		Handler: searchHistoryTool,
+	})
+	utils.Info("已注册工具: %s", strings.Join(ListTools(), ", "))
+}
+
+func currentTimeTool(_ context.Context, _ ToolContext, args json.RawMessage) (string, error) {
+	var in struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", fmt.Errorf("参数错误: %v", err)
+	}
+	loc := time.Local
+	if in.Timezone != "" {
+		l, err := time.LoadLocation(in.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("未知时区: %s", in.Timezone)
+		}
+		loc = l
+	}
+	now := time.Now().In(loc)
+	out, _ := json.Marshal(map[string]string{
+		"datetime": now.Format(time.RFC3339),
+		"weekday":  now.Weekday().String(),
+		"timezone": loc.String(),
+	})
+	return string(out), nil
+}
+
+func calculatorTool(_ context.Context, _ ToolContext, args json.RawMessage) (string, error) {
+	var in struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", fmt.Errorf("参数错误: %v", err)
+	}
+	v, err := EvalExpression(in.Expression)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64), nil
+}
+
+func searchHistoryTool(_ context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+	var in struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", fmt.Errorf("参数错误: %v", err)
+	}
+	query := strings.ToLower(strings.TrimSpace(in.Query))
+	if query == "" {
+		return "", errors.New("query 不能为空")
+	}
+	if in.Limit <= 0 {
+		in.Limit = defaultSearchResults
+	}
+	if in.Limit > maxSearchResults {
+		in.Limit = maxSearchResults
+	}
+
+	type hit struct {
+		Index   int    `json:"index"`
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	hits := []hit{}
+	// 从最近的消息开始检索
+	for i := len(tc.History) - 1; i >= 0 && len(hits) < in.Limit; i-- {
+		m := tc.History[i]
+		if m.Role != "user" && m.Role != "assistant" {
+			continue
+		}
+		if pos := strings.Index(strings.ToLower(m.Content), query); pos >= 0 {
+			hits = append(hits, hit{Index: i, Role: m.Role, Content: snippet(m.Content, pos)})
+		}
+	}
+	out, _ := json.Marshal(map[string]interface{}{"matches": hits})
+	return string(out), nil
+}
+
+// snippet 截取匹配位置附近的片段
+func snippet(content string, pos int) string {
+	if utf8.RuneCountInString(content) <= searchSnippetRunes {
+		return content
+	}
+	runes := []rune(content)
+	center := utf8.RuneCountInString(content[:pos])
+	start := center - searchSnippetRunes/4
+	if start < 0 {
+		start = 0
+	}
+	end := start + searchSnippetRunes
+	if end > len(runes) {
+		end = len(runes)
+		start = end - searchSnippetRunes
+	}
+	s := string(runes[start:end])
+	if start > 0 {
+		s = "..." + s
+	}
+	if end < len(runes) {
+		s += "..."
+	}
+	return s
+}
+
+// EvalExpression 计算数学表达式，仅支持数字、运算符、括号与白名单内的函数和常量
+func EvalExpression(expr string) (float64, error) {
+	if len(expr) > maxExpressionLength {
+		return 0, fmt.Errorf("表达式过长（最多 %d 个字符）", maxExpressionLength)
+	}
+	p := &exprParser{src: expr}
+	v, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return 0, fmt.Errorf("无法解析位置 %d 处的内容: %q", p.pos, p.src[p.pos:])
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, errors.New("计算结果不是有限数值")
+	}
+	return v, nil
+}
+
+var exprFuncs = map[string]func(float64) float64{
+	"sqrt":  math.Sqrt,
+	"abs":   math.Abs,
+	"round": math.Round,
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+	"ln":    math.Log,
+	"log10": math.Log10,
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+}
+
+var exprConsts = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+// exprParser 递归下降解析器：
+// sum = product {("+"|"-") product}; product = unary {("*"|"/"|"%") unary};
+// unary = ("+"|"-") unary | power; power = atom ["^" unary]; atom = number | name ["(" sum ")"] | "(" sum ")"
+type exprParser struct {
+	src   string
+	pos   int
+	depth int
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// peek 跳过空白后返回下一个字符，到达末尾时返回0
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *exprParser) enter() error {
+	p.depth++
+	if p.depth > maxExpressionDepth {
+		return errors.New("表达式嵌套过深")
+	}
+	return nil
+}
+
+func (p *exprParser) parseSum() (float64, error) {
+	v, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '+':
+			p.pos++
+			r, err := p.parseProduct()
+			if err != nil {
+				return 0, err
+			}
+			v += r
+		case '-':
+			p.pos++
+			r, err := p.parseProduct()
+			if err != nil {
+				return 0, err
+			}
+			v -= r
+		default:
+			return v, nil
+		}
+	}
+}
+
+func (p *exprParser) parseProduct() (float64, error) {
+	v, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return v, nil
+		}
+		p.pos++
+		r, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			v *= r
+		case '/':
+			if r == 0 {
+				return 0, errors.New("除数不能为0")
+			}
+			v /= r
+		case '%':
+			if r == 0 {
+				return 0, errors.New("除数不能为0")
+			}
+			v = math.Mod(v, r)
+		}
+	}
+}
+
+func (p *exprParser) parseUnary() (float64, error) {
+	if err := p.enter(); err != nil {
+		return 0, err
+	}
+	defer func() { p.depth-- }()
+
+	switch p.peek() {
+	case '+':
+		p.pos++
+		return p.parseUnary()
+	case '-':
+		p.pos++
+		v, err := p.parseUnary()
+		return -v, err
+	}
+	return p.parsePower()
+}
+
+func (p *exprParser) parsePower() (float64, error) {
+	base, err := p.parseAtom()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != '^' {
+		return base, nil
+	}
+	p.pos++
+	// 右结合：2^3^2 = 2^(3^2)
+	exp, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exp), nil
+}
+
+func (p *exprParser) parseAtom() (float64, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return 0, errors.New("表达式不完整")
+	case c == '(':
+		p.pos++
+		return p.parseGroup()
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		// 科学计数法，如 1e-3
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			end := p.pos + 1
+			if end < len(p.src) && (p.src[end] == '+' || p.src[end] == '-') {
+				end++
+			}
+			if end < len(p.src) && p.src[end] >= '0' && p.src[end] <= '9' {
+				for end < len(p.src) && p.src[end] >= '0' && p.src[end] <= '9' {
+					end++
+				}
+				p.pos = end
+			}
+		}
+		v, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return 0, fmt.Errorf("无效的数字: %q", p.src[start:p.pos])
+		}
+		return v, nil
+	case unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.src) && (unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		name := strings.ToLower(p.src[start:p.pos])
+		if v, ok := exprConsts[name]; ok {
+			return v, nil
+		}
+		fn, ok := exprFuncs[name]
+		if !ok {
+			return 0, fmt.Errorf("不支持的函数或常量: %s", name)
+		}
+		if p.peek() != '(' {
+			return 0, fmt.Errorf("函数 %s 后缺少括号", name)
+		}
+		p.pos++
+		arg, err := p.parseGroup()
+		if err != nil {
+			return 0, err
+		}
+		return fn(arg), nil
+	default:
+		return 0, fmt.Errorf("无法识别的字符: %q", c)
+	}
+}
+
+// parseGroup 解析左括号之后的表达式与右括号
+func (p *exprParser) parseGroup() (float64, error) {
+	if err := p.enter(); err != nil {
+		return 0, err
+	}
+	defer func() { p.depth-- }()
+
+	v, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != ')' {
+		return 0, errors.New("缺少右括号")
+	}
+	p.pos++
+	return v, nil
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEvalExpression(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    float64
+		wantErr bool
+	}{
+		{expr: "1 + 2 * 3", want: 7},
+		{expr: "(1 + 2) * 3", want: 9},
+		{expr: "10 / 4", want: 2.5},
+		{expr: "10 % 4", want: 2},
+		{expr: "2 ^ 3 ^ 2", want: 512},
+		{expr: "-2 ^ 2", want: -4},
+		{expr: "--3", want: 3},
+		{expr: "1.5e3 + .5", want: 1500.5},
+		{expr: "2e-1", want: 0.2},
+		{expr: "sqrt(16) + abs(-3)", want: 7},
+		{expr: "round(2.5) + floor(1.9) + ceil(0.1)", want: 5},
+		{expr: "PI * 2", want: 2 * math.Pi},
+		{expr: "ln(e)", want: 1},
+		{expr: "log10(1000)", want: 3},
+		{expr: "  1\t+ 1 ", want: 2},
+		{expr: "", wantErr: true},
+		{expr: "1 +", wantErr: true},
+		{expr: "(1 + 2", wantErr: true},
+		{expr: "1 + 2)", wantErr: true},
+		{expr: "1 / 0", wantErr: true},
+		{expr: "5 % 0", wantErr: true},
+		{expr: "sqrt(-1)", wantErr: true},
+		{expr: "10 ^ 400", wantErr: true},
+		{expr: "exp(1)", wantErr: true},
+		{expr: "sqrt 4", wantErr: true},
+		{expr: "1..2", wantErr: true},
+		{expr: "2 $ 3", wantErr: true},
+		{expr: strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100), wantErr: true},
+		{expr: strings.Repeat("1+", 200) + "1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := EvalExpression(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EvalExpression(%q) err = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+			if !tt.wantErr && math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("EvalExpression(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
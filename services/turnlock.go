@@ -0,0 +1,93 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/utils"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSessionBusy 会话上一轮对话尚未结束（reject 模式，或排队超时）
+var ErrSessionBusy = errors.New("该会话上一轮对话尚未结束，请稍后重试")
+
+// turnLock 单个会话的对话锁，refs 为持有与等待者的数量，归零时从表中移除
+type turnLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+var (
+	turnLocksMu sync.Mutex
+	turnLocks   = map[string]*turnLock{}
+)
+
+// LockTurn 获取会话的对话锁。一轮对话从读取历史到写入回复须持有该锁，
+// 保证同一会话的各轮依次读写历史，不会交错。上一轮未结束时按
+// config.C.Session.ConcurrentTurns 排队等待或立即返回 ErrSessionBusy；
+// 排队期间 ctx 结束则返回 ctx 的错误。返回的 unlock 可重复调用
+func LockTurn(ctx context.Context, sessionID string) (unlock func(), err error) {
+	l := acquireTurnLock(sessionID)
+
+	select {
+	case l.ch <- struct{}{}:
+	default:
+		if config.C.Session.ConcurrentTurns == "reject" {
+			releaseTurnLock(sessionID, l)
+			return nil, ErrSessionBusy
+		}
+		utils.FromContext(ctx).Debug("等待会话上一轮对话结束(session=%s)", sessionID)
+		if err := waitTurn(ctx, l); err != nil {
+			releaseTurnLock(sessionID, l)
+			return nil, err
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-l.ch
+			releaseTurnLock(sessionID, l)
+		})
+	}, nil
+}
+
+// waitTurn 排队等待对话锁，超过 config.C.TurnWait() 时返回 ErrSessionBusy
+func waitTurn(ctx context.Context, l *turnLock) error {
+	var timeout <-chan time.Time
+	if wait := config.C.TurnWait(); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.ch <- struct{}{}:
+		return nil
+	case <-timeout:
+		return ErrSessionBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func acquireTurnLock(sessionID string) *turnLock {
+	turnLocksMu.Lock()
+	defer turnLocksMu.Unlock()
+	l, ok := turnLocks[sessionID]
+	if !ok {
+		l = &turnLock{ch: make(chan struct{}, 1)}
+		turnLocks[sessionID] = l
+	}
+	l.refs++
+	return l
+}
+
+func releaseTurnLock(sessionID string, l *turnLock) {
+	turnLocksMu.Lock()
+	defer turnLocksMu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(turnLocks, sessionID)
+	}
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"AiDemo/config"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockTurn(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		wait    int // 秒
+		hold    time.Duration
+		cancel  time.Duration // 大于0时在此之后取消等待
+		wantErr error
+	}{
+		{name: "reject 模式立即拒绝", mode: "reject", hold: time.Second, wantErr: ErrSessionBusy},
+		{name: "queue 模式等待上一轮结束", mode: "queue", wait: 1, hold: 50 * time.Millisecond},
+		{name: "queue 模式不限时", mode: "queue", hold: 50 * time.Millisecond},
+		{name: "排队超时", mode: "queue", wait: 1, hold: 3 * time.Second, wantErr: ErrSessionBusy},
+		{name: "排队期间取消", mode: "queue", hold: time.Second, cancel: 50 * time.Millisecond, wantErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *config.Config) {
+				c.Session.ConcurrentTurns = tt.mode
+				c.Session.TurnWait = tt.wait
+			})
+			const id = "lock-test"
+
+			unlock, err := LockTurn(context.Background(), id)
+			if err != nil {
+				t.Fatalf("LockTurn: %v", err)
+			}
+			released := make(chan struct{})
+			timer := time.AfterFunc(tt.hold, func() {
+				unlock()
+				close(released)
+			})
+			defer func() {
+				timer.Stop()
+				unlock()
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+			unlock2, err := LockTurn(ctx, id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LockTurn err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			select {
+			case <-released:
+			default:
+				t.Fatal("上一轮未释放时获得了对话锁")
+			}
+			unlock2()
+			unlock2() // 重复调用无效
+		})
+	}
+
+	turnLocksMu.Lock()
+	defer turnLocksMu.Unlock()
+	if len(turnLocks) != 0 {
+		t.Errorf("释放后仍残留 %d 个对话锁", len(turnLocks))
+	}
+}
+
+func TestLockTurnIndependentSessions(t *testing.T) {
+	withConfig(t, func(c *config.Config) { c.Session.ConcurrentTurns = "reject" })
+
+	unlockA, err := LockTurn(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("LockTurn(a): %v", err)
+	}
+	defer unlockA()
+	unlockB, err := LockTurn(context.Background(), "b")
+	if err != nil {
+		t.Fatalf("不同会话的对话锁互不影响: %v", err)
+	}
+	unlockB()
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"AiDemo/config"
+	"AiDemo/models"
+	"AiDemo/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 摘要压缩产生的用量记在此角色名下
+const summaryUsageRole = "_summary"
+
+// usageRecord 用量账本中的一行：按 日期 + 角色 + 模型 聚合
+type usageRecord struct {
+	Day   string       `json:"day"` // 2006-01-02
+	Role  string       `json:"role"`
+	Model string       `json:"model"`
+	Usage models.Usage `json:"usage"`
+}
+
+type usageKey struct {
+	day, role, model string
+}
+
+// 用量账本
+var (
+	ledger    = make(map[usageKey]*usageRecord)
+	ledgerLog *os.File // 追加写入的账本文件，为nil时仅保存在内存中
+	ledgerMu  sync.Mutex
+)
+
+// UsageBucket 聚合后的一组用量
+type UsageBucket struct {
+	Key string `json:"key"`
+	models.Usage
+}
+
+// UsageReport 用量查询结果
+type UsageReport struct {
+	Currency string        `json:"currency"`
+	Total    models.Usage  `json:"total"`
+	ByDay    []UsageBucket `json:"by_day"`
+	ByRole   []UsageBucket `json:"by_role"`
+	ByModel  []UsageBucket `json:"by_model"`
+}
+
+// UsageQuery 用量查询条件，空字段表示不过滤；日期格式为 2006-01-02，包含边界
+type UsageQuery struct {
+	From string
+	To   string
+	Role string
+}
+
+// InitUsage 初始化用量账本。file不为空时从文件加载，合并为每个 日期+角色+模型 一行后写回，
+// 之后每次记录只向文件末尾追加一行增量
+func InitUsage(file string) error {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	if file == "" {
+		return nil
+	}
+	n, err := loadLedger(file)
+	if err != nil {
+		return err
+	}
+	if err := compactLedger(file); err != nil {
+		return fmt.Errorf("写回用量账本失败: %w", err)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("打开用量账本失败: %w", err)
+	}
+	ledgerLog = f
+	utils.Info("已从 %s 加载 %d 条用量记录", file, n)
+	return nil
+}
+
+// loadLedger 读取账本文件并累加到内存账本，返回读到的记录数。
+// 文件为每行一条JSON记录，同一 日期+角色+模型 可出现多行；兼容旧版的JSON数组格式。
+// 无法解析的行（如写入中途退出留下的半行）跳过
+func loadLedger(file string) (int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("读取用量账本失败: %w", err)
+	}
+
+	var records []usageRecord
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &records); err != nil {
+			return 0, fmt.Errorf("解析用量账本失败: %w", err)
+		}
+	} else {
+		for i, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var r usageRecord
+			if err := json.Unmarshal(line, &r); err != nil {
+				utils.Warning("用量账本第 %d 行无法解析，已跳过: %v", i+1, err)
+				continue
+			}
+			records = append(records, r)
+		}
+	}
+
+	for _, r := range records {
+		key := usageKey{r.Day, r.Role, r.Model}
+		rec, ok := ledger[key]
+		if !ok {
+			rec = &usageRecord{Day: r.Day, Role: r.Role, Model: r.Model}
+			ledger[key] = rec
+		}
+		rec.Usage.Add(r.Usage)
+	}
+	return len(records), nil
+}
+
+// CloseUsage 关闭用量账本文件
+func CloseUsage() {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	if ledgerLog == nil {
+		return
+	}
+	if err := ledgerLog.Close(); err != nil {
+		utils.Warning("关闭用量账本失败: %v", err)
+	}
+	ledgerLog = nil
+}
+
+// PriceUsage 按价格表计算用量对应的费用
+func PriceUsage(model string, u models.Usage) float64 {
+	price := config.C.PriceFor(model)
+	return float64(u.PromptTokens)/1000*price.Prompt + float64(u.CompletionTokens)/1000*price.Completion
+}
+
+// RecordUsage 计算费用后将用量计入会话、角色与当日的统计，返回带费用的用量
+func RecordUsage(ctx context.Context, sessionID, role, model string, u models.Usage) models.Usage {
+	u.Cost = PriceUsage(model, u)
+
+	if sessionID != "" {
+		if err := store.AddUsage(sessionID, u); err != nil {
+			utils.FromContext(ctx).Error("记录会话用量失败(session=%s): %v", sessionID, err)
+		}
+	}
+
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	key := usageKey{time.Now().Format("2006-01-02"), role, model}
+	rec, ok := ledger[key]
+	if !ok {
+		rec = &usageRecord{Day: key.day, Role: role, Model: model}
+		ledger[key] = rec
+	}
+	rec.Usage.Add(u)
+
+	if err := appendLedger(usageRecord{Day: key.day, Role: role, Model: model, Usage: u}); err != nil {
+		utils.FromContext(ctx).Error("保存用量账本失败: %v", err)
+	}
+	return u
+}
+
+// QueryUsage 按条件汇总用量
+func QueryUsage(q UsageQuery) UsageReport {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	report := UsageReport{Currency: config.C.Pricing.Currency}
+	byDay := make(map[string]*models.Usage)
+	byRole := make(map[string]*models.Usage)
+	byModel := make(map[string]*models.Usage)
+	add := func(m map[string]*models.Usage, key string, u models.Usage) {
+		if m[key] == nil {
+			m[key] = &models.Usage{}
+		}
+		m[key].Add(u)
+	}
+
+	for _, r := range ledger {
+		if (q.From != "" && r.Day < q.From) || (q.To != "" && r.Day > q.To) || (q.Role != "" && r.Role != q.Role) {
+			continue
+		}
+		report.Total.Add(r.Usage)
+		add(byDay, r.Day, r.Usage)
+		add(byRole, r.Role, r.Usage)
+		add(byModel, r.Model, r.Usage)
+	}
+
+	report.ByDay = toBuckets(byDay)
+	report.ByRole = toBuckets(byRole)
+	report.ByModel = toBuckets(byModel)
+	return report
+}
+
+func toBuckets(m map[string]*models.Usage) []UsageBucket {
+	buckets := make([]UsageBucket, 0, len(m))
+	for k, u := range m {
+		buckets = append(buckets, UsageBucket{Key: k, Usage: *u})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Key < buckets[j].Key })
+	return buckets
+}
+
+// appendLedger 向账本文件追加一行增量记录，调用方需持有 ledgerMu
+func appendLedger(r usageRecord) error {
+	if ledgerLog == nil {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = ledgerLog.Write(append(data, '\n'))
+	return err
+}
+
+// compactLedger 将内存账本按 日期、角色、模型 排序后原子写回文件，每条记录一行，调用方需持有 ledgerMu
+func compactLedger(file string) error {
+	records := make([]usageRecord, 0, len(ledger))
+	for _, r := range ledger {
+		records = append(records, *r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		return a.Model < b.Model
+	})
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,8 +9,10 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,6 +46,13 @@ const (
 	FlushInterval     = 3    // 默认刷新间隔（秒）
 )
 
+// callerDepth 从 getCaller 到业务调用方的栈深度：getCaller <- log <- Info 等 <- 调用方。
+// 包级便捷函数直接调用默认记录器的 log，与方法的栈深度相同
+const callerDepth = 3
+
+// Fields 结构化日志字段
+type Fields = map[string]interface{}
+
 // LogEntry 结构化日志条目
 type LogEntry struct {
 	Level     string                 `json:"level"`
@@ -59,7 +69,7 @@ type logMessage struct {
 	args      []interface{}
 	timestamp time.Time
 	caller    string
-	fields    map[string]interface{}
+	fields    Fields
 }
 
 // Logger 日志记录器结构体
@@ -73,6 +83,8 @@ type Logger struct {
 	currentLogFile string
 	rotateEnabled  bool
 	lastRotateTime time.Time
+	rotateOpts     RotateOptions // 轮转策略，见 SetRotateOptions
+	fileSize       int64         // 当前日志文件的大小，用于按大小轮转
 	mutex          sync.Mutex
 	format         int // 日志格式
 
@@ -83,6 +95,16 @@ type Logger struct {
 	flushInterval time.Duration
 	bufferSize    int
 	stopChan      chan struct{}
+
+	// 轮转出的文件在后台压缩与清理，cleanupMu 保证同一时刻只有一个清理任务
+	cleanupMu  sync.Mutex
+	cleanupWG  sync.WaitGroup
+	activeFile atomic.Value // 正在写入的日志文件路径，清理任务不会处理该文件
+
+	// 子记录器：parent 为写出日志的根记录器，fields 为每条日志附带的字段。
+	// 子记录器的级别、格式、轮转与异步设置均取自根记录器
+	parent *Logger
+	fields Fields
 }
 
 var defaultLogger *Logger
@@ -101,12 +123,19 @@ func init() {
 	}
 }
 
-// NewLogger 创建新的日志记录器
+// NewLogger 创建新的日志记录器，日志文件按默认的轮转策略（按天）命名
 func NewLogger(level int, logFilePath string, showCaller bool) *Logger {
+	return newLogger(level, logFilePath, showCaller, RotateOptions{})
+}
+
+// newLogger 创建日志记录器，首个日志文件与轮转时一样由 getRotatedFileName 按opts选取，
+// 重启后不会写入已压缩或已写满的文件
+func newLogger(level int, logFilePath string, showCaller bool, opts RotateOptions) *Logger {
 	var writer io.Writer = os.Stdout
 	var logFile *os.File
 	var err error
 	var logDir, baseFileName, currentLogFile string
+	var fileSize int64
 
 	// 如果指定了日志文件路径，同时写入文件和标准输出
 	if logFilePath != "" {
@@ -121,18 +150,16 @@ func NewLogger(level int, logFilePath string, showCaller bool) *Logger {
 		}
 
 		baseFileName = filepath.Base(logFilePath)
-		ext := filepath.Ext(baseFileName)
-		baseFileName = strings.TrimSuffix(baseFileName, ext) + ext
 
-		// 直接使用带日期的文件名
-		now := time.Now()
-		dateStr := now.Format("2006-01-02")
-		fileNameWithoutExt := strings.TrimSuffix(baseFileName, ext)
-		currentLogFile = filepath.Join(logDir, fmt.Sprintf("%s.%s%s", fileNameWithoutExt, dateStr, ext))
+		// 使用带周期与序号的文件名
+		currentLogFile = (&Logger{logDir: logDir, baseFileName: baseFileName, rotateOpts: opts}).getRotatedFileName()
 
 		logFile, err = os.OpenFile(currentLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 		if err == nil {
 			writer = io.MultiWriter(os.Stdout, logFile)
+			if info, err := logFile.Stat(); err == nil {
+				fileSize = info.Size()
+			}
 		} else {
 			fmt.Printf("无法打开日志文件: %v，将只输出到控制台\n", err)
 		}
@@ -140,7 +167,7 @@ func NewLogger(level int, logFilePath string, showCaller bool) *Logger {
 
 	logger := log.New(writer, "", 0)
 
-	return &Logger{
+	l := &Logger{
 		level:          level,
 		logFile:        logFile,
 		logger:         logger,
@@ -149,17 +176,22 @@ func NewLogger(level int, logFilePath string, showCaller bool) *Logger {
 		baseFileName:   baseFileName,
 		currentLogFile: currentLogFile,
 		rotateEnabled:  false,
+		rotateOpts:     opts,
 		lastRotateTime: time.Now(),
+		fileSize:       fileSize,
 		mutex:          sync.Mutex{},
 		format:         TextFormat, // 默认为文本格式
 		asyncEnabled:   false,
 		bufferSize:     DefaultBufferSize,
 		flushInterval:  FlushInterval * time.Second,
 	}
+	l.activeFile.Store(currentLogFile)
+	return l
 }
 
 // SetLevel 设置日志级别
 func (l *Logger) SetLevel(level int) {
+	l = l.root()
 	if level >= DEBUG && level <= FATAL {
 		l.level = level
 	}
@@ -172,6 +204,7 @@ func SetLevel(level int) {
 
 // SetFormat 设置日志格式
 func (l *Logger) SetFormat(format int) {
+	l = l.root()
 	if format == TextFormat || format == JsonFormat {
 		l.format = format
 	}
@@ -184,6 +217,7 @@ func SetFormat(format int) {
 
 // EnableAsync 启用异步日志
 func (l *Logger) EnableAsync(bufferSize int, flushInterval time.Duration) {
+	l = l.root()
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
@@ -217,6 +251,7 @@ func EnableAsync(bufferSize int, flushInterval time.Duration) {
 
 // DisableAsync 禁用异步日志
 func (l *Logger) DisableAsync() {
+	l = l.root()
 	l.mutex.Lock()
 
 	if !l.asyncEnabled {
@@ -239,6 +274,7 @@ func DisableAsync() {
 
 // Flush 刷新异步日志缓冲区
 func (l *Logger) Flush() {
+	l = l.root()
 	if !l.asyncEnabled {
 		return
 	}
@@ -288,8 +324,8 @@ func (l *Logger) processLogs() {
 				continue
 			}
 
-			// 正常日志处理
-			l.writeLog(msg)
+			// 正常日志处理，写入前检查是否需要轮转
+			l.writeLogSync(msg)
 
 		case <-ticker.C:
 			// 定期检查是否需要轮转
@@ -311,7 +347,7 @@ func (l *Logger) drainLogChannel() {
 			if !ok {
 				return
 			}
-			l.writeLog(msg)
+			l.writeLogSync(msg)
 		default:
 			// 通道已清空
 			close(l.logChan)
@@ -330,16 +366,12 @@ func (l *Logger) checkAndRotate() {
 	}
 }
 
-// SetLogFile 设置日志文件
+// SetLogFile 设置日志文件，按已设置的轮转策略选取文件名。默认记录器原地切换输出，此前创建的子记录器随之生效
 func SetLogFile(logFilePath string) error {
-	if defaultLogger.logFile != nil {
-		err := defaultLogger.logFile.Close()
-		if err != nil {
-			return fmt.Errorf("关闭当前日志文件失败: %w", err)
-		}
-	}
-
-	logger := NewLogger(defaultLogger.level, logFilePath, defaultLogger.showCaller)
+	defaultLogger.mutex.Lock()
+	opts := defaultLogger.rotateOpts
+	defaultLogger.mutex.Unlock()
+	logger := newLogger(defaultLogger.level, logFilePath, defaultLogger.showCaller, opts)
 	if logger == nil {
 		return fmt.Errorf("创建新的日志记录器失败")
 	}
@@ -358,7 +390,21 @@ func SetLogFile(logFilePath string) error {
 		defaultLogger.DisableAsync()
 	}
 
-	defaultLogger = logger
+	defaultLogger.mutex.Lock()
+	if defaultLogger.logFile != nil {
+		if err := defaultLogger.logFile.Close(); err != nil {
+			fmt.Printf("关闭当前日志文件失败: %v\n", err)
+		}
+	}
+	defaultLogger.logFile = logger.logFile
+	defaultLogger.logger = logger.logger
+	defaultLogger.logDir = logger.logDir
+	defaultLogger.baseFileName = logger.baseFileName
+	defaultLogger.currentLogFile = logger.currentLogFile
+	defaultLogger.activeFile.Store(logger.currentLogFile)
+	defaultLogger.lastRotateTime = logger.lastRotateTime
+	defaultLogger.fileSize = logger.fileSize
+	defaultLogger.mutex.Unlock()
 
 	// 如果原来是异步的，重新启用
 	if wasAsync {
@@ -370,6 +416,7 @@ func SetLogFile(logFilePath string) error {
 
 // EnableRotate 启用日志轮转
 func (l *Logger) EnableRotate() {
+	l = l.root()
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
@@ -389,6 +436,7 @@ func EnableRotate() {
 
 // DisableRotate 禁用日志轮转
 func (l *Logger) DisableRotate() {
+	l = l.root()
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
@@ -400,71 +448,6 @@ func DisableRotate() {
 	defaultLogger.DisableRotate()
 }
 
-// 生成轮转后的日志文件名
-func (l *Logger) getRotatedFileName() string {
-	if l.baseFileName == "" {
-		return ""
-	}
-
-	now := time.Now()
-	ext := filepath.Ext(l.baseFileName)
-	baseName := strings.TrimSuffix(l.baseFileName, ext)
-
-	// 按天格式化日期
-	timeFormat := "2006-01-02"
-
-	return filepath.Join(l.logDir, fmt.Sprintf("%s.%s%s", baseName, now.Format(timeFormat), ext))
-}
-
-// 检查是否需要轮转
-func (l *Logger) checkRotate() bool {
-	if !l.rotateEnabled || l.logFile == nil {
-		return false
-	}
-
-	now := time.Now()
-
-	// 检查日期是否变化（按天轮转）
-	return now.Day() != l.lastRotateTime.Day() ||
-		now.Month() != l.lastRotateTime.Month() || now.Year() != l.lastRotateTime.Year()
-}
-
-// 执行日志轮转
-func (l *Logger) rotate() {
-	var err error
-
-	if l.logFile == nil {
-		return
-	}
-
-	// 关闭当前日志文件
-	err = l.logFile.Close()
-	if err != nil {
-		fmt.Printf("关闭日志文件失败: %v\n", err)
-		return
-	}
-
-	// 生成新的日志文件名
-	newLogFile := l.getRotatedFileName()
-	if newLogFile == "" {
-		return
-	}
-
-	// 打开新的日志文件
-	l.logFile, err = os.OpenFile(newLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		fmt.Printf("轮转日志文件失败: %v，将只输出到控制台\n", err)
-		l.logFile = nil
-		l.logger = log.New(os.Stdout, "", 0)
-	} else {
-		l.logger = log.New(io.MultiWriter(os.Stdout, l.logFile), "", 0)
-		l.currentLogFile = newLogFile
-	}
-
-	// 更新最后轮转时间
-	l.lastRotateTime = time.Now()
-}
-
 // getCaller 获取调用者信息
 func getCaller(skip int) string {
 	_, file, line, ok := runtime.Caller(skip)
@@ -476,52 +459,96 @@ func getCaller(skip int) string {
 	return fmt.Sprintf("%s:%d", short, line)
 }
 
-// log 记录日志的内部方法
-func (l *Logger) log(level int, format string, args ...interface{}) {
-	if level < l.level {
+// log 记录日志的内部方法，depth 为 getCaller 跳过的栈深度
+func (l *Logger) log(depth, level int, format string, args ...interface{}) {
+	r := l.root()
+	if level < r.level {
 		return
 	}
 
 	// 获取调用者信息
 	var callerInfo string
-	if l.showCaller {
-		callerInfo = getCaller(3) // 跳过自身调用栈
+	if r.showCaller {
+		callerInfo = getCaller(depth)
+	}
+
+	// 兼容旧用法：最后一个参数为字段映射时作为字段输出
+	var extra Fields
+	if len(args) > 0 && format != "" {
+		extra, _ = args[len(args)-1].(map[string]interface{})
 	}
 
+	r.dispatch(&logMessage{
+		level:     level,
+		format:    format,
+		args:      args,
+		timestamp: time.Now(),
+		caller:    callerInfo,
+		fields:    l.mergeFields(extra),
+	})
+}
+
+// output 写出一条已格式化的消息，时间与调用位置由调用方给出，供 slog 等适配器使用。
+// message 不再按格式串解析，fields 与记录器附带的字段合并
+func (l *Logger) output(level int, t time.Time, caller, message string, fields Fields) {
+	r := l.root()
+	if level < r.level {
+		return
+	}
+	if !r.showCaller {
+		caller = ""
+	}
+	r.dispatch(&logMessage{
+		level:     level,
+		args:      []interface{}{message},
+		timestamp: t,
+		caller:    caller,
+		fields:    l.mergeFields(fields),
+	})
+}
+
+// dispatch 按异步设置写出日志消息，须在根记录器上调用
+func (l *Logger) dispatch(msg *logMessage) {
 	// 如果启用异步，将日志消息发送到通道
-	if l.asyncEnabled && level != FATAL { // 致命错误仍然同步处理
+	if l.asyncEnabled && msg.level != FATAL { // 致命错误仍然同步处理
 		select {
-		case l.logChan <- &logMessage{
-			level:     level,
-			format:    format,
-			args:      args,
-			timestamp: time.Now(),
-			caller:    callerInfo,
-		}:
+		case l.logChan <- msg:
 			// 成功发送到通道
 		default:
 			// 通道已满，回退到同步写入
-			l.writeLogSync(level, format, callerInfo, args...)
-		}
-
-		// 如果是致命错误，等待日志写入完成后退出
-		if level == FATAL {
-			l.Flush()
-			os.Exit(1)
+			l.writeLogSync(msg)
 		}
 	} else {
 		// 同步写入日志
-		l.writeLogSync(level, format, callerInfo, args...)
+		l.writeLogSync(msg)
 
 		// 如果是致命错误，程序退出
-		if level == FATAL {
+		if msg.level == FATAL {
 			os.Exit(1)
 		}
 	}
 }
 
+// mergeFields 合并记录器附带的字段与extra，同名键以extra为准，均无时返回nil
+func (l *Logger) mergeFields(extra Fields) Fields {
+	if len(extra) == 0 {
+		return l.fields
+	}
+	if len(l.fields) == 0 {
+		return extra
+	}
+	merged := make(Fields, len(l.fields)+len(extra))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
+
 // writeLogSync 同步写入日志
-func (l *Logger) writeLogSync(level int, format, callerInfo string, args ...interface{}) {
+func (l *Logger) writeLogSync(msg *logMessage) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
@@ -530,14 +557,6 @@ func (l *Logger) writeLogSync(level int, format, callerInfo string, args ...inte
 		l.rotate()
 	}
 
-	msg := &logMessage{
-		level:     level,
-		format:    format,
-		args:      args,
-		timestamp: time.Now(),
-		caller:    callerInfo,
-	}
-
 	l.writeLog(msg)
 }
 
@@ -558,21 +577,13 @@ func (l *Logger) writeLog(msg *logMessage) {
 		content = fmt.Sprintf(msg.format, msg.args...)
 	}
 
-	// 检查最后一个参数是否为字段映射
-	var fields map[string]interface{}
-	if len(msg.args) > 0 {
-		if f, ok := msg.args[len(msg.args)-1].(map[string]interface{}); ok && msg.format != "" {
-			fields = f
-		}
-	}
-
 	// 根据格式输出日志
 	if l.format == JsonFormat {
 		entry := LogEntry{
 			Level:     levelNames[msg.level],
 			Timestamp: timestamp,
 			Message:   content,
-			Fields:    fields,
+			Fields:    msg.fields,
 		}
 
 		if l.showCaller && msg.caller != "" {
@@ -582,9 +593,9 @@ func (l *Logger) writeLog(msg *logMessage) {
 		jsonData, err := json.Marshal(entry)
 		if err != nil {
 			// 如果JSON序列化失败，回退到文本格式
-			l.logger.Print(fmt.Sprintf("[%s] %s [%s] %s", levelNames[msg.level], timestamp, msg.caller, content))
+			l.emit(fmt.Sprintf("[%s] %s [%s] %s", levelNames[msg.level], timestamp, msg.caller, content))
 		} else {
-			l.logger.Print(string(jsonData))
+			l.emit(string(jsonData))
 		}
 	} else {
 		// 文本格式
@@ -592,58 +603,155 @@ func (l *Logger) writeLog(msg *logMessage) {
 		if l.showCaller && msg.caller != "" {
 			callerStr = " [" + msg.caller + "]"
 		}
-		l.logger.Printf("[%s] %s%s %s", levelNames[msg.level], timestamp, callerStr, content)
+		l.emit(fmt.Sprintf("[%s] %s%s %s%s", levelNames[msg.level], timestamp, callerStr, content, formatFields(msg.fields)))
+	}
+}
+
+// emit 输出一行日志并累计当前日志文件的大小
+func (l *Logger) emit(line string) {
+	l.logger.Print(line)
+	if l.logFile != nil {
+		l.fileSize += int64(len(line)) + 1
 	}
 }
 
+// formatFields 把字段按键排序格式化为文本格式日志的后缀，如 " request_id=ab12 session=s1"
+func formatFields(fields Fields) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %s=%v", k, fields[k])
+	}
+	return sb.String()
+}
+
 // Debug 调试级别日志
 func (l *Logger) Debug(format string, args ...interface{}) {
-	l.log(DEBUG, format, args...)
+	l.log(callerDepth, DEBUG, format, args...)
 }
 
 // Info 信息级别日志
 func (l *Logger) Info(format string, args ...interface{}) {
-	l.log(INFO, format, args...)
+	l.log(callerDepth, INFO, format, args...)
 }
 
 // Warning 警告级别日志
 func (l *Logger) Warning(format string, args ...interface{}) {
-	l.log(WARNING, format, args...)
+	l.log(callerDepth, WARNING, format, args...)
 }
 
 // Error 错误级别日志
 func (l *Logger) Error(format string, args ...interface{}) {
-	l.log(ERROR, format, args...)
+	l.log(callerDepth, ERROR, format, args...)
 }
 
 // Fatal 致命错误级别日志，记录后程序退出
 func (l *Logger) Fatal(format string, args ...interface{}) {
-	l.log(FATAL, format, args...)
+	l.log(callerDepth, FATAL, format, args...)
 }
 
 // Debug 默认日志记录器的便捷方法
 func Debug(format string, args ...interface{}) {
-	defaultLogger.Debug(format, args...)
+	defaultLogger.log(callerDepth, DEBUG, format, args...)
 }
 
 func Info(format string, args ...interface{}) {
-	defaultLogger.Info(format, args...)
+	defaultLogger.log(callerDepth, INFO, format, args...)
 }
 
 func Warning(format string, args ...interface{}) {
-	defaultLogger.Warning(format, args...)
+	defaultLogger.log(callerDepth, WARNING, format, args...)
 }
 
 func Error(format string, args ...interface{}) {
-	defaultLogger.Error(format, args...)
+	defaultLogger.log(callerDepth, ERROR, format, args...)
 }
 
 func Fatal(format string, args ...interface{}) {
-	defaultLogger.Fatal(format, args...)
+	defaultLogger.log(callerDepth, FATAL, format, args...)
+}
+
+// With 返回附带字段的子记录器。args 为交替的键值对，也可直接传入 Fields；
+// 子记录器的字段包含父记录器的字段，同名键以后者为准
+func (l *Logger) With(args ...interface{}) *Logger {
+	fields := make(Fields, len(l.fields)+len(args)/2)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for i := 0; i < len(args); i++ {
+		switch a := args[i].(type) {
+		case map[string]interface{}:
+			for k, v := range a {
+				fields[k] = v
+			}
+		case string:
+			if i+1 < len(args) {
+				fields[a] = args[i+1]
+				i++
+			} else {
+				fields["!BADKEY"] = a
+			}
+		default:
+			fields["!BADKEY"] = a
+		}
+	}
+	return &Logger{parent: l.root(), fields: fields}
+}
+
+// WithFields 返回附带fields的子记录器
+func (l *Logger) WithFields(fields Fields) *Logger {
+	return l.With(fields)
+}
+
+// root 返回写出日志的根记录器
+func (l *Logger) root() *Logger {
+	if l.parent != nil {
+		return l.parent
+	}
+	return l
+}
+
+// With 返回默认日志记录器附带字段的子记录器
+func With(args ...interface{}) *Logger {
+	return defaultLogger.With(args...)
+}
+
+// WithFields 返回默认日志记录器附带fields的子记录器
+func WithFields(fields Fields) *Logger {
+	return defaultLogger.WithFields(fields)
+}
+
+// loggerKey 记录器在 context 中的键
+type loggerKey struct{}
+
+// NewContext 返回携带记录器l的ctx
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext 返回ctx携带的记录器，未携带时返回默认日志记录器
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok && l != nil {
+		return l
+	}
+	return defaultLogger
+}
+
+// ContextWith 在ctx携带的记录器上附加字段，返回携带子记录器的ctx
+func ContextWith(ctx context.Context, args ...interface{}) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(args...))
 }
 
 // Close 关闭日志文件
 func (l *Logger) Close() {
+	l = l.root()
 	// 如果启用了异步日志，先禁用它
 	if l.asyncEnabled {
 		l.DisableAsync()
@@ -652,6 +760,9 @@ func (l *Logger) Close() {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
+	// 等待进行中的压缩与清理完成
+	l.cleanupWG.Wait()
+
 	if l.logFile != nil {
 		err := l.logFile.Close()
 		if err != nil {
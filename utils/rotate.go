@@ -0,0 +1,332 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 轮转周期
+const (
+	RotateDaily  = iota // 按天轮转，文件名如 app.2023-05-20.log
+	RotateHourly        // 按小时轮转，文件名如 app.2023-05-20-15.log
+)
+
+// RotateOptions 日志轮转策略，启用轮转（EnableRotate）后生效。
+// 同一周期内文件达到 MaxSize 时切换到带序号的新文件，如 app.2023-05-20.1.log；
+// 轮转出的文件在后台压缩，并按 MaxAge 与 MaxTotal 清理
+type RotateOptions struct {
+	Interval int           // 轮转周期：RotateDaily 或 RotateHourly
+	MaxSize  int64         // 单个日志文件的最大字节数，0表示不按大小轮转
+	Compress bool          // 轮转出的文件以 gzip 压缩为 .gz
+	MaxAge   time.Duration // 轮转文件最后写入后的保留时长，0表示不限
+	MaxTotal int64         // 日志文件（含当前文件）的总字节数上限，超出时从最旧的轮转文件开始删除，0表示不限
+}
+
+// layout 返回周期对应的文件名时间格式
+func (o RotateOptions) layout() string {
+	if o.Interval == RotateHourly {
+		return "2006-01-02-15"
+	}
+	return "2006-01-02"
+}
+
+// SetRotateOptions 设置日志轮转策略，下次轮转时生效
+func (l *Logger) SetRotateOptions(opts RotateOptions) {
+	l = l.root()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if opts.Interval != RotateHourly {
+		opts.Interval = RotateDaily
+	}
+	l.rotateOpts = opts
+}
+
+// SetRotateOptions 设置默认日志记录器的日志轮转策略
+func SetRotateOptions(opts RotateOptions) {
+	defaultLogger.SetRotateOptions(opts)
+}
+
+// rotatedFilePattern 匹配由 baseFileName 轮转出的文件，子匹配依次为周期、序号与压缩后缀
+func (l *Logger) rotatedFilePattern() *regexp.Regexp {
+	ext := filepath.Ext(l.baseFileName)
+	name := strings.TrimSuffix(l.baseFileName, ext)
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `\.(\d{4}-\d{2}-\d{2}(?:-\d{2})?)(?:\.(\d+))?` +
+		regexp.QuoteMeta(ext) + `(\.gz)?$`)
+}
+
+// 生成当前周期应写入的日志文件名。周期内已有文件时沿用序号最大的一个，
+// 它已被压缩或达到 MaxSize 时使用下一个序号
+func (l *Logger) getRotatedFileName() string {
+	if l.baseFileName == "" {
+		return ""
+	}
+
+	period := time.Now().Format(l.rotateOpts.layout())
+	last, lastPlain, lastSize := -1, false, int64(0)
+	if entries, err := os.ReadDir(l.logDir); err == nil {
+		re := l.rotatedFilePattern()
+		for _, e := range entries {
+			m := re.FindStringSubmatch(e.Name())
+			if m == nil || m[1] != period {
+				continue
+			}
+			n, _ := strconv.Atoi(m[2]) // 无序号为0
+			if n > last {
+				last, lastPlain = n, false
+			}
+			if n == last && m[3] == "" {
+				lastPlain = true
+				if info, err := e.Info(); err == nil {
+					lastSize = info.Size()
+				}
+			}
+		}
+	}
+
+	index := last
+	if index < 0 {
+		index = 0
+	} else if !lastPlain || (l.rotateOpts.MaxSize > 0 && lastSize >= l.rotateOpts.MaxSize) {
+		index++
+	}
+
+	ext := filepath.Ext(l.baseFileName)
+	name := fmt.Sprintf("%s.%s", strings.TrimSuffix(l.baseFileName, ext), period)
+	if index > 0 {
+		name += "." + strconv.Itoa(index)
+	}
+	return filepath.Join(l.logDir, name+ext)
+}
+
+// 检查是否需要轮转：进入新的周期，或当前文件达到 MaxSize
+func (l *Logger) checkRotate() bool {
+	if !l.rotateEnabled || l.logFile == nil {
+		return false
+	}
+
+	if l.rotateOpts.MaxSize > 0 && l.fileSize >= l.rotateOpts.MaxSize {
+		return true
+	}
+	layout := l.rotateOpts.layout()
+	return time.Now().Format(layout) != l.lastRotateTime.Format(layout)
+}
+
+// 执行日志轮转
+func (l *Logger) rotate() {
+	var err error
+
+	if l.logFile == nil {
+		return
+	}
+
+	// 关闭当前日志文件
+	err = l.logFile.Close()
+	if err != nil {
+		fmt.Printf("关闭日志文件失败: %v\n", err)
+		return
+	}
+
+	// 生成新的日志文件名
+	newLogFile := l.getRotatedFileName()
+	if newLogFile == "" {
+		return
+	}
+
+	// 打开新的日志文件，先登记为正在写入，避免被后台清理任务压缩
+	l.activeFile.Store(newLogFile)
+	l.logFile, err = os.OpenFile(newLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		fmt.Printf("轮转日志文件失败: %v，将只输出到控制台\n", err)
+		l.logFile = nil
+		l.logger = log.New(os.Stdout, "", 0)
+	} else {
+		l.logger = log.New(io.MultiWriter(os.Stdout, l.logFile), "", 0)
+		l.currentLogFile = newLogFile
+		l.fileSize = 0
+		if info, err := l.logFile.Stat(); err == nil {
+			l.fileSize = info.Size()
+		}
+	}
+
+	// 更新最后轮转时间
+	l.lastRotateTime = time.Now()
+
+	l.startCleanup()
+}
+
+// startCleanup 在后台压缩轮转出的文件并按保留策略删除旧文件，调用方须持有 l.mutex
+func (l *Logger) startCleanup() {
+	opts := l.rotateOpts
+	if !opts.Compress && opts.MaxAge <= 0 && opts.MaxTotal <= 0 {
+		return
+	}
+
+	dir, re := l.logDir, l.rotatedFilePattern()
+	active := func() string {
+		path, _ := l.activeFile.Load().(string)
+		return path
+	}
+	l.cleanupWG.Add(1)
+	go func() {
+		defer l.cleanupWG.Done()
+		l.cleanupMu.Lock()
+		defer l.cleanupMu.Unlock()
+		cleanupLogFiles(dir, active, re, opts)
+	}()
+}
+
+// rotatedFile 目录中的一个轮转文件
+type rotatedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// cleanupLogFiles 压缩dir中未压缩的轮转文件，再按最后写入时间从新到旧保留，
+// 删除超过 MaxAge 的文件以及累计大小超出 MaxTotal 后的全部文件。
+// active 返回正在写入的文件，逐个文件检查：清理期间发生轮转时，新旧文件都不会被误处理。
+// 出错时只打印，不影响日志写入
+func cleanupLogFiles(dir string, active func() string, re *regexp.Regexp, opts RotateOptions) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Printf("读取日志目录失败: %v\n", err)
+		return
+	}
+
+	var files []rotatedFile
+	var total int64
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		m := re.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if path == active() {
+			if info, err := e.Info(); err == nil {
+				total += info.Size()
+			}
+			continue
+		}
+		if opts.Compress && m[3] == "" {
+			if gz, err := compressLogFile(path, re); err != nil {
+				fmt.Printf("压缩日志文件失败: %v\n", err)
+			} else {
+				path = gz
+			}
+		}
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+	}
+
+	if opts.MaxAge <= 0 && opts.MaxTotal <= 0 {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	now := time.Now()
+	for _, f := range files {
+		total += f.size
+		expired := opts.MaxAge > 0 && now.Sub(f.modTime) > opts.MaxAge
+		if expired || (opts.MaxTotal > 0 && total > opts.MaxTotal) {
+			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("删除旧日志文件失败: %v\n", err)
+			}
+		}
+	}
+}
+
+// compressedPath 返回path压缩后的文件名，通常为 path.gz。path.gz 已存在时
+// （如同一周期内重启后又写入了同名文件）改用该周期未被占用的下一个序号，re 为 rotatedFilePattern
+func compressedPath(path string, re *regexp.Regexp) string {
+	gzPath := path + ".gz"
+	if _, err := os.Lstat(gzPath); os.IsNotExist(err) {
+		return gzPath
+	}
+
+	// 按子匹配位置拆出“名称.周期”与扩展名，中间的序号替换为新的序号
+	dir, name := filepath.Split(path)
+	loc := re.FindStringSubmatchIndex(name)
+	if loc == nil {
+		return gzPath
+	}
+	head, tail := name[:loc[3]], loc[3]
+	if loc[4] >= 0 {
+		tail = loc[5]
+	}
+	ext := name[tail:]
+	for n := 1; ; n++ {
+		candidate := filepath.Join(dir, head+"."+strconv.Itoa(n)+ext)
+		if _, err := os.Lstat(candidate); !os.IsNotExist(err) {
+			continue
+		}
+		if _, err := os.Lstat(candidate + ".gz"); os.IsNotExist(err) {
+			return candidate + ".gz"
+		}
+	}
+}
+
+// compressLogFile 将path压缩为 .gz 并删除原文件，压缩文件沿用原文件的修改时间以便按时间清理。
+// 不会覆盖已有的压缩文件：目标已存在时按 compressedPath 改用其他序号。
+// 先写入临时文件再以硬链接落盘，中途失败不会留下不完整的 .gz
+func compressLogFile(path string, re *regexp.Regexp) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	gzPath := compressedPath(path, re)
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return "", err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = strings.TrimSuffix(filepath.Base(gzPath), ".gz")
+	zw.ModTime = info.ModTime()
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		// 与 Rename 不同，Link 在目标已存在时失败，不会覆盖其他任务写出的压缩文件
+		err = os.Link(tmp, gzPath)
+	}
+	os.Remove(tmp)
+	if err != nil {
+		return "", err
+	}
+
+	src.Close()
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+	return gzPath, nil
+}
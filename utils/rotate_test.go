@@ -0,0 +1,343 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLogFile 在dir中写入name，内容为size字节，修改时间为modTime（零值表示不修改）
+func writeLogFile(t *testing.T, dir, name string, size int, modTime time.Time) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listDir 返回dir中的文件名，已排序
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestGetRotatedFileName(t *testing.T) {
+	day := time.Now().Format("2006-01-02")
+	hour := time.Now().Format("2006-01-02-15")
+	tests := []struct {
+		name     string
+		opts     RotateOptions
+		existing map[string]int // 已有文件名 -> 大小
+		want     string
+	}{
+		{name: "按天", opts: RotateOptions{Interval: RotateDaily}, want: "app." + day + ".log"},
+		{name: "按小时", opts: RotateOptions{Interval: RotateHourly}, want: "app." + hour + ".log"},
+		{
+			name:     "沿用未满的文件",
+			opts:     RotateOptions{MaxSize: 10},
+			existing: map[string]int{"app." + day + ".log": 5},
+			want:     "app." + day + ".log",
+		},
+		{
+			name:     "达到MaxSize后使用下一个序号",
+			opts:     RotateOptions{MaxSize: 10},
+			existing: map[string]int{"app." + day + ".log": 10},
+			want:     "app." + day + ".1.log",
+		},
+		{
+			name:     "未设置MaxSize时不按大小切换",
+			existing: map[string]int{"app." + day + ".log": 1 << 20},
+			want:     "app." + day + ".log",
+		},
+		{
+			name: "沿用序号最大的文件",
+			opts: RotateOptions{MaxSize: 10},
+			existing: map[string]int{
+				"app." + day + ".log.gz": 3,
+				"app." + day + ".1.log":  10,
+				"app." + day + ".2.log":  4,
+			},
+			want: "app." + day + ".2.log",
+		},
+		{
+			name:     "最大序号已压缩时使用下一个序号",
+			existing: map[string]int{"app." + day + ".log": 1, "app." + day + ".1.log.gz": 3},
+			want:     "app." + day + ".2.log",
+		},
+		{
+			name:     "忽略其他周期与无关文件",
+			existing: map[string]int{"app.2000-01-01.5.log": 1, "app." + day + ".bak": 1, "other." + day + ".3.log": 1},
+			want:     "app." + day + ".log",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, size := range tt.existing {
+				writeLogFile(t, dir, name, size, time.Time{})
+			}
+			l := &Logger{logDir: dir, baseFileName: "app.log", rotateOpts: tt.opts}
+			if got := l.getRotatedFileName(); got != filepath.Join(dir, tt.want) {
+				t.Errorf("getRotatedFileName = %s, want %s", filepath.Base(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestRotatedFilePattern(t *testing.T) {
+	re := (&Logger{baseFileName: "app.log"}).rotatedFilePattern()
+	tests := []struct {
+		name string
+		want []string // 周期、序号、压缩后缀；nil表示不匹配
+	}{
+		{name: "app.2024-05-20.log", want: []string{"2024-05-20", "", ""}},
+		{name: "app.2024-05-20-15.3.log", want: []string{"2024-05-20-15", "3", ""}},
+		{name: "app.2024-05-20.1.log.gz", want: []string{"2024-05-20", "1", ".gz"}},
+		{name: "app.log"},
+		{name: "app.2024-05-20.log.gz.tmp"},
+		{name: "xapp.2024-05-20.log"},
+	}
+	for _, tt := range tests {
+		m := re.FindStringSubmatch(tt.name)
+		var got []string
+		if m != nil {
+			got = m[1:]
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: 子匹配 = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCleanupLogFiles(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+	type file struct {
+		name    string
+		size    int
+		modTime time.Time
+	}
+	tests := []struct {
+		name  string
+		opts  RotateOptions
+		files []file
+		want  []string
+	}{
+		{
+			name: "压缩轮转出的文件，跳过正在写入的文件",
+			opts: RotateOptions{Compress: true},
+			files: []file{
+				{name: "app.2024-05-19.log", size: 10, modTime: ago(time.Hour)},
+				{name: "app.2024-05-20.log.gz", size: 10, modTime: ago(time.Minute)},
+				{name: "app.2024-05-21.log", size: 10},
+				{name: "notes.txt", size: 10},
+			},
+			want: []string{"app.2024-05-19.log.gz", "app.2024-05-20.log.gz", "app.2024-05-21.log", "notes.txt"},
+		},
+		{
+			name: "删除超过MaxAge的文件",
+			opts: RotateOptions{MaxAge: 24 * time.Hour},
+			files: []file{
+				{name: "app.2024-05-01.log", size: 10, modTime: ago(72 * time.Hour)},
+				{name: "app.2024-05-19.log.gz", size: 10, modTime: ago(48 * time.Hour)},
+				{name: "app.2024-05-20.log", size: 10, modTime: ago(time.Hour)},
+				{name: "app.2024-05-21.log", size: 10, modTime: ago(72 * time.Hour)},
+			},
+			want: []string{"app.2024-05-20.log", "app.2024-05-21.log"},
+		},
+		{
+			name: "超出MaxTotal时从最旧的文件开始删除，计入当前文件",
+			opts: RotateOptions{MaxTotal: 25},
+			files: []file{
+				{name: "app.2024-05-18.log", size: 10, modTime: ago(3 * time.Hour)},
+				{name: "app.2024-05-19.log", size: 10, modTime: ago(2 * time.Hour)},
+				{name: "app.2024-05-20.log", size: 10, modTime: ago(time.Hour)},
+				{name: "app.2024-05-21.log", size: 10},
+			},
+			want: []string{"app.2024-05-20.log", "app.2024-05-21.log"},
+		},
+		{
+			name: "按修改时间而非文件名排序",
+			opts: RotateOptions{MaxTotal: 20},
+			files: []file{
+				{name: "app.2024-05-19.1.log", size: 10, modTime: ago(time.Hour)},
+				{name: "app.2024-05-19.2.log", size: 10, modTime: ago(2 * time.Hour)},
+				{name: "app.2024-05-21.log", size: 10},
+			},
+			want: []string{"app.2024-05-19.1.log", "app.2024-05-21.log"},
+		},
+		{
+			name: "未设置上限时不删除",
+			files: []file{
+				{name: "app.2024-05-01.log", size: 10, modTime: ago(1000 * time.Hour)},
+				{name: "app.2024-05-21.log", size: 10},
+			},
+			want: []string{"app.2024-05-01.log", "app.2024-05-21.log"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				writeLogFile(t, dir, f.name, f.size, f.modTime)
+			}
+			l := &Logger{logDir: dir, baseFileName: "app.log"}
+			active := filepath.Join(dir, "app.2024-05-21.log")
+			cleanupLogFiles(dir, func() string { return active }, l.rotatedFilePattern(), tt.opts)
+			if got := listDir(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("目录内容 = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressLogFileKeepsModTime(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	writeLogFile(t, dir, "app.2024-05-20.log", 100, modTime)
+
+	re := (&Logger{baseFileName: "app.log"}).rotatedFilePattern()
+	gz, err := compressLogFile(filepath.Join(dir, "app.2024-05-20.log"), re)
+	if err != nil {
+		t.Fatalf("compressLogFile: %v", err)
+	}
+	info, err := os.Stat(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("ModTime = %v, want %v", info.ModTime(), modTime)
+	}
+	if got, want := listDir(t, dir), []string{"app.2024-05-20.log.gz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("目录内容 = %q, want %q", got, want)
+	}
+}
+
+func TestCompressLogFileKeepsExistingArchive(t *testing.T) {
+	re := (&Logger{baseFileName: "app.log"}).rotatedFilePattern()
+	tests := []struct {
+		name     string
+		existing []string
+		compress string
+		want     string
+	}{
+		{name: "无冲突", compress: "app.2024-05-20.log", want: "app.2024-05-20.log.gz"},
+		{
+			name:     "同名压缩文件已存在时使用下一个序号",
+			existing: []string{"app.2024-05-20.log.gz"},
+			compress: "app.2024-05-20.log",
+			want:     "app.2024-05-20.1.log.gz",
+		},
+		{
+			name:     "跳过已占用的序号",
+			existing: []string{"app.2024-05-20.log.gz", "app.2024-05-20.1.log.gz", "app.2024-05-20.2.log"},
+			compress: "app.2024-05-20.log",
+			want:     "app.2024-05-20.3.log.gz",
+		},
+		{
+			name:     "带序号的文件",
+			existing: []string{"app.2024-05-20-15.1.log.gz"},
+			compress: "app.2024-05-20-15.1.log",
+			want:     "app.2024-05-20-15.2.log.gz",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.existing {
+				writeLogFile(t, dir, name, 7, time.Time{})
+			}
+			writeLogFile(t, dir, tt.compress, 100, time.Time{})
+
+			gz, err := compressLogFile(filepath.Join(dir, tt.compress), re)
+			if err != nil {
+				t.Fatalf("compressLogFile: %v", err)
+			}
+			if gz != filepath.Join(dir, tt.want) {
+				t.Errorf("压缩文件 = %s, want %s", filepath.Base(gz), tt.want)
+			}
+			for _, name := range tt.existing {
+				if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Size() != 7 {
+					t.Errorf("已有文件 %s 被修改: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewLoggerRestartSamePeriod(t *testing.T) {
+	day := time.Now().Format("2006-01-02")
+	hour := time.Now().Format("2006-01-02-15")
+	tests := []struct {
+		name     string
+		opts     RotateOptions
+		existing map[string]int
+		want     string
+	}{
+		{
+			name:     "上次的文件已压缩",
+			existing: map[string]int{"app." + day + ".log.gz": 10},
+			want:     "app." + day + ".1.log",
+		},
+		{
+			name:     "上次的文件已写满",
+			opts:     RotateOptions{MaxSize: 10},
+			existing: map[string]int{"app." + day + ".log.gz": 10, "app." + day + ".1.log": 10},
+			want:     "app." + day + ".2.log",
+		},
+		{
+			name:     "继续写入未满的文件",
+			opts:     RotateOptions{MaxSize: 10},
+			existing: map[string]int{"app." + day + ".log.gz": 10, "app." + day + ".1.log": 5},
+			want:     "app." + day + ".1.log",
+		},
+		{
+			name: "按小时轮转时首个文件按小时命名",
+			opts: RotateOptions{Interval: RotateHourly},
+			want: "app." + hour + ".log",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, size := range tt.existing {
+				writeLogFile(t, dir, name, size, time.Time{})
+			}
+			l := newLogger(INFO, filepath.Join(dir, "app.log"), false, tt.opts)
+			defer l.logFile.Close()
+			if l.currentLogFile != filepath.Join(dir, tt.want) {
+				t.Errorf("首个日志文件 = %s, want %s", filepath.Base(l.currentLogFile), tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "app."+day+".log")); tt.want != "app."+day+".log" && err == nil {
+				t.Error("创建了多余的 app.<日期>.log")
+			}
+			for name, size := range tt.existing {
+				if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Size() != int64(size) {
+					t.Errorf("已有文件 %s 被修改: %v", name, err)
+				}
+			}
+		})
+	}
+}
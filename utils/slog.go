@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SlogHandler 写入 Logger 的 slog.Handler，使经 log/slog 输出的日志同样按本日志系统的
+// 级别过滤、格式化（文本或JSON）、轮转与异步写入。
+// 记录的属性映射为字段，分组以 "." 连接为键的前缀，如 WithGroup("http") 下的 status 记为 http.status；
+// ctx 携带记录器时（见 NewContext）其字段一并输出，便于与请求日志关联
+type SlogHandler struct {
+	logger *Logger
+	fields Fields // WithAttrs 附加的字段，键已带分组前缀
+	prefix string // WithGroup 累积的键前缀
+}
+
+// NewSlogHandler 创建写入l的 slog.Handler，l 为 nil 时写入默认日志记录器
+func NewSlogHandler(l *Logger) *SlogHandler {
+	if l == nil {
+		l = defaultLogger
+	}
+	return &SlogHandler{logger: l}
+}
+
+// Enabled 按记录器的日志级别判断是否输出
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return slogLevel(level) >= h.logger.root().level
+}
+
+// Handle 将一条 slog 记录写入记录器
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := make(Fields, len(h.fields)+r.NumAttrs())
+	if ctx != nil {
+		if cl, ok := ctx.Value(loggerKey{}).(*Logger); ok && cl != nil {
+			for k, v := range cl.fields {
+				fields[k] = v
+			}
+		}
+	}
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addSlogAttr(fields, h.prefix, a)
+		return true
+	})
+
+	var caller string
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		caller = fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+	}
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	h.logger.output(slogLevel(r.Level), t, caller, r.Message, fields)
+	return nil
+}
+
+// WithAttrs 返回附加了attrs的处理器
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.fields = make(Fields, len(h.fields)+len(attrs))
+	for k, v := range h.fields {
+		h2.fields[k] = v
+	}
+	for _, a := range attrs {
+		addSlogAttr(h2.fields, h.prefix, a)
+	}
+	return &h2
+}
+
+// WithGroup 返回此后的属性都归入name分组的处理器
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+// addSlogAttr 将属性写入fields，分组属性展开为带前缀的键；空属性与空分组按 slog 的约定忽略
+func addSlogAttr(fields Fields, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			addSlogAttr(fields, prefix, ga)
+		}
+		return
+	}
+	fields[prefix+a.Key] = slogValue(a.Value)
+}
+
+// slogValue 将属性值转换为字段值，时间与时长转为字符串，错误取其信息
+func slogValue(v slog.Value) interface{} {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+	}
+	return v.Any()
+}
+
+// slogLevel 将 slog 级别映射为日志级别，介于两级之间的取较低者
+func slogLevel(level slog.Level) int {
+	switch {
+	case level < slog.LevelInfo:
+		return DEBUG
+	case level < slog.LevelWarn:
+		return INFO
+	case level < slog.LevelError:
+		return WARNING
+	default:
+		return ERROR
+	}
+}
+
+// Writer 返回按行写入记录器的 io.Writer，每行一条level级别的日志，
+// 用于接管只支持 io.Writer 的输出（如 gin.DefaultErrorWriter）
+func (l *Logger) Writer(level int) io.Writer {
+	return &lineWriter{logger: l, level: level}
+}
+
+type lineWriter struct {
+	logger *Logger
+	level  int
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			w.logger.output(w.level, time.Now(), "", line, nil)
+		}
+	}
+	return len(p), nil
+}
+
+// Writer 返回按行写入默认日志记录器的 io.Writer
+func Writer(level int) io.Writer {
+	return defaultLogger.Writer(level)
+}
+
+// RouteStdLog 将 log/slog 的默认记录器设为写入默认日志记录器的 SlogHandler。
+// slog.SetDefault 同时会把标准库 log 包的输出转为 INFO 级别的 slog 记录，
+// 因此两者都经由本日志系统写出；log 包自带的时间前缀随之关闭，避免重复
+func RouteStdLog() {
+	log.SetFlags(0)
+	slog.SetDefault(slog.New(NewSlogHandler(defaultLogger)))
+}
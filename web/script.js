@@ -2,6 +2,58 @@
 let waitingForAIResponse = false;
 let sessionId = getOrCreateSessionId();
 
+loadRoles();
+
+// 带上本地保存的 API Key 发起请求；服务端启用认证且返回401时提示输入后重试一次
+function apiFetch(url, options = {}, retried = false) {
+    const headers = Object.assign({}, options.headers);
+    let apiKey = "";
+    try { apiKey = localStorage.getItem('ai_api_key') || ""; } catch (e) { }
+    if (apiKey) headers["X-API-Key"] = apiKey;
+    return fetch(url, Object.assign({}, options, { headers })).then(res => {
+        if (res.status !== 401 || retried) return res;
+        const key = window.prompt("请输入 API Key");
+        if (!key) return res;
+        try { localStorage.setItem('ai_api_key', key.trim()); } catch (e) { }
+        return apiFetch(url, options, true);
+    });
+}
+
+// 从服务端加载角色列表，构建角色下拉框
+function loadRoles() {
+    const roleSelect = document.getElementById("role-select");
+    if (!roleSelect) return;
+    apiFetch("/roles")
+        .then(res => { if (!res.ok) throw new Error("HTTP " + res.status); return res.json(); })
+        .then(data => {
+            const roles = (data && data.roles) || [];
+            if (!roles.length) return;
+            const current = roleSelect.value || data.default;
+            roleSelect.innerHTML = "";
+            roles.forEach(r => {
+                const opt = document.createElement("option");
+                opt.value = r.id;
+                opt.textContent = r.name || r.id;
+                roleSelect.appendChild(opt);
+            });
+            roleSelect.value = roles.some(r => r.id === current) ? current : data.default;
+        })
+        .catch(err => console.error("加载角色失败", err));
+    roleSelect.addEventListener("change", switchRole);
+}
+
+// 切换角色：已有会话通过 PATCH 切换，会话尚未创建时（404）在下一条消息中使用新角色
+function switchRole() {
+    const role = document.getElementById("role-select").value;
+    apiFetch("/sessions/" + encodeURIComponent(sessionId), {
+        method: "PATCH",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ role })
+    })
+        .then(res => { if (!res.ok && res.status !== 404) throw new Error("HTTP " + res.status); })
+        .catch(err => console.error("切换角色失败", err));
+}
+
 function getOrCreateSessionId() {
     try {
         const k = 'ai_session_id';
@@ -21,9 +73,11 @@ function sendMessage() {
 
     const inputElement = document.getElementById("input");
     const roleSelect = document.getElementById("role-select");
+    const imageInput = document.getElementById("image-input");
     const message = inputElement.value.trim();
     const role = roleSelect ? roleSelect.value : "general";
-    if (!message) return;
+    const images = imageInput ? Array.from(imageInput.files) : [];
+    if (!message && !images.length) return;
 
     inputElement.value = "";
 
@@ -31,8 +85,30 @@ function sendMessage() {
     const userEl = document.createElement("div");
     userEl.className = "message user";
     userEl.textContent = "你: " + message;
+    images.forEach(file => {
+        const img = document.createElement("img");
+        img.src = URL.createObjectURL(file);
+        userEl.appendChild(img);
+    });
     document.getElementById("chat-box").appendChild(userEl);
 
+    // 有图片时以 multipart/form-data 上传，否则发送JSON
+    let request = {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ message, role, session_id: sessionId })
+    };
+    if (images.length) {
+        const form = new FormData();
+        form.append("message", message);
+        form.append("role", role);
+        form.append("session_id", sessionId);
+        images.forEach(file => form.append("images", file));
+        request = { method: "POST", body: form };
+        imageInput.value = "";
+        updateAttachHint();
+    }
+
     // AI 占位
     const aiEl = document.createElement("div");
     aiEl.className = "message ai typing";
@@ -41,43 +117,82 @@ function sendMessage() {
     waitingForAIResponse = true;
     scrollToBottom();
 
-    fetch("/chat", {
-        method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ message, role, session_id: sessionId })
-    })
-        .then(res => { if (!res.ok) throw new Error("HTTP " + res.status); return res.json(); })
-        .then(data => {
-            aiEl.textContent = "AI: ";
-            aiEl.classList.remove("typing");
-            if (data && data.session_id) {
-                // 以服务端返回为准（兼容后端生成）
-                sessionId = data.session_id;
-                try { localStorage.setItem('ai_session_id', sessionId); } catch (e) { }
-            }
-            typeText(aiEl, data.reply || "出错了，请稍后再试");
+    apiFetch("/chat/stream", request)
+        .then(res => {
+            if (!res.ok || !res.body) throw new Error("HTTP " + res.status);
+            let text = "";
+            return readSSE(res.body, (event, data) => {
+                if (event === "session" || event === "done") {
+                    if (data && data.session_id) {
+                        // 以服务端返回为准（兼容后端生成）
+                        sessionId = data.session_id;
+                        try { localStorage.setItem('ai_session_id', sessionId); } catch (e) { }
+                    }
+                } else if (event === "delta") {
+                    if (!text) aiEl.classList.remove("typing");
+                    text += (data && data.content) || "";
+                    aiEl.textContent = "AI: " + text;
+                    scrollToBottom();
+                } else if (event === "error") {
+                    throw new Error((data && data.error) || "stream error");
+                }
+            }).then(() => {
+                if (!text) throw new Error("empty reply");
+            });
         })
         .catch(err => {
             console.error(err);
             aiEl.textContent = "AI: 出错了，请稍后再试";
+        })
+        .finally(() => {
             aiEl.classList.remove("typing");
             waitingForAIResponse = false;
         });
 }
 
-function typeText(element, text) {
-    let i = 0;
-    const prefix = "AI: ";
-    (function type() {
-        if (i < text.length) {
-            element.textContent = prefix + text.substring(0, i + 1);
-            i++;
-            scrollToBottom();
-            setTimeout(type, 30);
-        } else {
-            waitingForAIResponse = false;
-        }
-    })();
+// 在附加图片按钮上显示已选择的图片数
+function updateAttachHint() {
+    const imageInput = document.getElementById("image-input");
+    const hint = document.getElementById("attach-hint");
+    if (!imageInput || !hint) return;
+    hint.textContent = imageInput.files.length ? " " + imageInput.files.length : "";
+}
+
+// 读取SSE响应流，按事件回调 onEvent(eventName, parsedData)
+function readSSE(body, onEvent) {
+    const reader = body.getReader();
+    const decoder = new TextDecoder();
+    let buffer = "";
+
+    function dispatch(block) {
+        let event = "message";
+        const dataLines = [];
+        block.split("\n").forEach(line => {
+            if (line.startsWith("event:")) event = line.slice(6).trim();
+            else if (line.startsWith("data:")) dataLines.push(line.slice(5));
+        });
+        if (!dataLines.length) return;
+        let data = dataLines.join("\n");
+        try { data = JSON.parse(data); } catch (e) { }
+        onEvent(event, data);
+    }
+
+    function pump() {
+        return reader.read().then(({ done, value }) => {
+            if (done) {
+                if (buffer.trim()) dispatch(buffer);
+                return;
+            }
+            buffer += decoder.decode(value, { stream: true }).replace(/\r\n/g, "\n");
+            let idx;
+            while ((idx = buffer.indexOf("\n\n")) >= 0) {
+                dispatch(buffer.slice(0, idx));
+                buffer = buffer.slice(idx + 2);
+            }
+            return pump();
+        });
+    }
+    return pump();
 }
 
 function scrollToBottom() {